@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/screwdriver-cd/sd-step/hab"
+	"github.com/screwdriver-cd/sd-step/hab/habtest"
+	"github.com/screwdriver-cd/sd-step/step"
+)
+
+var e2eBuild struct {
+	once   sync.Once
+	dir    string
+	sdStep string
+	hab    string
+	err    error
+}
+
+// buildE2EBinaries builds sd-step and the fake hab command once for all end-to-end tests.
+func buildE2EBinaries(t *testing.T) (string, string) {
+	if testing.Short() {
+		t.Skip("skipping end-to-end test in short mode")
+	}
+
+	e2eBuild.once.Do(func() {
+		e2eBuild.dir, e2eBuild.err = ioutil.TempDir("", "sd-step-e2e")
+		if e2eBuild.err != nil {
+			return
+		}
+		e2eBuild.sdStep = filepath.Join(e2eBuild.dir, "sd-step")
+		e2eBuild.hab = filepath.Join(e2eBuild.dir, "hab")
+
+		for pkg, output := range map[string]string{".": e2eBuild.sdStep, "./hab/habtest/fakehab": e2eBuild.hab} {
+			out, err := exec.Command("go", "build", "-o", output, pkg).CombinedOutput()
+			if err != nil {
+				e2eBuild.err = &buildError{pkg, err, out}
+				return
+			}
+		}
+	})
+
+	if e2eBuild.err != nil {
+		t.Fatalf("Unable to build binaries: %v", e2eBuild.err)
+	}
+	return e2eBuild.sdStep, e2eBuild.hab
+}
+
+type buildError struct {
+	pkg    string
+	err    error
+	output []byte
+}
+
+func (e *buildError) Error() string {
+	return e.pkg + ": " + e.err.Error() + "\n" + string(e.output)
+}
+
+// e2eEnv is a sandbox with a fake depot and a fake hab command installing into a temporary root.
+type e2eEnv struct {
+	t      *testing.T
+	sdStep string
+	hab    string
+	root   string
+	log    string
+	index  string
+	depot  *habtest.Server
+}
+
+func newE2EEnv(t *testing.T, depotPackages []hab.PackageInfo, installablePackages []hab.PackageInfo) *e2eEnv {
+	sdStep, habCmd := buildE2EBinaries(t)
+
+	dir := t.TempDir()
+	env := &e2eEnv{
+		t:      t,
+		sdStep: sdStep,
+		hab:    habCmd,
+		root:   filepath.Join(dir, "root"),
+		log:    filepath.Join(dir, "hab.log"),
+		index:  filepath.Join(dir, "index.json"),
+		depot:  habtest.NewServer(depotPackages...),
+	}
+	t.Cleanup(env.depot.Close)
+
+	index, err := json.Marshal(installablePackages)
+	if err != nil {
+		t.Fatalf("Unable to marshal index: %v", err)
+	}
+	if err := ioutil.WriteFile(env.index, index, 0644); err != nil {
+		t.Fatalf("Unable to write index: %v", err)
+	}
+
+	return env
+}
+
+// run runs sd-step with args and returns its stdout, stderr and exit code.
+func (env *e2eEnv) run(extraEnv []string, args ...string) (string, string, int) {
+	globalFlags := []string{"--hab-path", env.hab, "--hab-root", env.root, "--depot-url", env.depot.DepotURL(), "--privilege", "none"}
+	// the flags of a subcommand follow it
+	n := 1
+	if args[0] == "bundle" {
+		n = 2
+	}
+	args = append(append(args[:n:n], globalFlags...), args[n:]...)
+
+	cmd := exec.Command(env.sdStep, args...)
+	cmd.Env = append(os.Environ(), "FAKEHAB_INDEX="+env.index, "FAKEHAB_LOG="+env.log)
+	cmd.Env = append(cmd.Env, extraEnv...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	code := 0
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		env.t.Fatalf("Unable to run sd-step: %v", err)
+	}
+	return stdout.String(), stderr.String(), code
+}
+
+// invocations returns the hab subcommands invoked so far, without the package arguments.
+func (env *e2eEnv) invocations() []string {
+	data, err := ioutil.ReadFile(env.log)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		env.t.Fatalf("Unable to read log: %v", err)
+	}
+
+	var invocations []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		fields := strings.Fields(line)
+		invocations = append(invocations, strings.Join(fields[:3], " "))
+	}
+	return invocations
+}
+
+func TestE2EExecInstallsThenExecutes(t *testing.T) {
+	packages := []hab.PackageInfo{
+		habtest.Package("foo/tool/1.0.0/20170524100001", "stable"),
+		habtest.Package("foo/tool/1.2.0/20170524100002", "stable"),
+		habtest.Package("foo/tool/2.0.0/20170524100003", "unstable"),
+	}
+	env := newE2EEnv(t, packages, packages)
+
+	stdout, stderr, code := env.run(nil, "exec", "--pkg-version", "^1.0.0", "foo/tool", "tool --flag")
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, actual %d: %s", code, stderr)
+	}
+	if expected := "foo/tool/1.2.0/20170524100002 --flag\n"; stdout != expected {
+		t.Errorf("Expected %q, actual %q", expected, stdout)
+	}
+	expected := []string{"pkg path foo/tool/1.2.0", "pkg install foo/tool/1.2.0", "pkg exec foo/tool/1.2.0"}
+	if invocations := env.invocations(); !reflect.DeepEqual(invocations, expected) {
+		t.Errorf("Expected invocations %q, actual %q", expected, invocations)
+	}
+
+	// the installed package is used as it is without accessing the depot
+	env.depot.Close()
+	stdout, stderr, code = env.run(nil, "exec", "--pkg-version", "1.2.0", "foo/tool", "tool")
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, actual %d: %s", code, stderr)
+	}
+	if expected := "foo/tool/1.2.0/20170524100002\n"; stdout != expected {
+		t.Errorf("Expected %q, actual %q", expected, stdout)
+	}
+	// installed packages are not used to resolve versions when fallback is disabled
+	_, stderr, code = env.run(nil, "exec", "--fallback", "never", "--pkg-version", "^1.0.0", "foo/tool", "tool")
+	if code != 5 {
+		t.Errorf("Expected exit code 5 without fallback, actual %d: %s", code, stderr)
+	}
+	stdout, stderr, code = env.run(nil, "exec", "--pkg-version", "^1.0.0", "foo/tool", "tool")
+	if code != 0 || stdout != "foo/tool/1.2.0/20170524100002\n" {
+		t.Errorf("Expected foo/tool/1.2.0 from installed packages, actual %q with exit code %d: %s", stdout, code, stderr)
+	}
+	if !strings.Contains(stderr, "WARNING: Resolving foo/tool from installed packages") {
+		t.Errorf("Expected the fallback to be reported, actual %q", stderr)
+	}
+
+	// a package which is not installed cannot be resolved without the depot
+	_, stderr, code = env.run(nil, "exec", "--pkg-version", "^1.0.0", "foo/other", "tool")
+	if code != 5 {
+		t.Errorf("Expected exit code 5 for unavailable depot, actual %d: %s", code, stderr)
+	}
+}
+
+func TestE2EExecFailures(t *testing.T) {
+	packages := []hab.PackageInfo{
+		habtest.Package("foo/tool/1.0.0/20170524100001", "stable"),
+		habtest.Package("foo/broken/1.0.0/20170524100001", "stable"),
+	}
+	env := newE2EEnv(t, packages, packages[:1])
+
+	tests := []struct {
+		name         string
+		env          []string
+		args         []string
+		expectedCode int
+		expectedErr  string
+	}{
+		{"install failure", nil, []string{"exec", "foo/broken", "broken"}, 1, "Cannot find a release of package"},
+		{"command failure", nil, []string{"exec", "foo/tool", "sh -c 'exit 3'"}, 1, "exit status 3"},
+		{"no matching version", nil, []string{"exec", "--pkg-version", "^2.0.0", "foo/tool", "tool"}, 3, "the specified version not found"},
+		{"invalid constraint", nil, []string{"exec", "--pkg-version", ">=1.0 <", "foo/tool", "tool"}, 2, "invalid constraint"},
+		{"package not found", nil, []string{"exec", "--pkg-version", "^1.0.0", "foo/missing", "tool"}, 4, "package not found"},
+		{"install timeout", []string{"FAKEHAB_INSTALL_DELAY=5s"}, []string{"exec", "--install-timeout", "100ms", "foo/tool", "tool"}, 124, "install timed out"},
+	}
+
+	for _, test := range tests {
+		os.RemoveAll(env.root)
+
+		_, stderr, code := env.run(test.env, test.args...)
+		if code != test.expectedCode {
+			t.Errorf("%s: expected exit code %d, actual %d: %s", test.name, test.expectedCode, code, stderr)
+		}
+		if !strings.Contains(stderr, test.expectedErr) {
+			t.Errorf("%s: expected %q in stderr, actual %q", test.name, test.expectedErr, stderr)
+		}
+	}
+}
+
+func TestE2EBundle(t *testing.T) {
+	packages := []hab.PackageInfo{
+		habtest.Package("foo/tool/1.0.0/20170524100001", "stable"),
+		habtest.Package("foo/tool/1.2.0/20170524100002", "stable"),
+		habtest.Package("bar/lib/2.0.0/20170524100003", "stable"),
+	}
+	env := newE2EEnv(t, packages, packages)
+	bundle := filepath.Join(t.TempDir(), "bundle.tar")
+
+	_, stderr, code := env.run(nil, "bundle", "create", "--pkg", "foo/tool@^1.0.0", "--pkg", "bar/lib", "-o", bundle)
+	if code != 0 {
+		t.Fatalf("Expected exit code 0 for bundle create, actual %d: %s", code, stderr)
+	}
+	// creating a bundle installs nothing into the hab root
+	if _, err := os.Stat(env.root); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be installed into the hab root, actual %v", err)
+	}
+
+	// the bundle is installed without the depot
+	env.depot.Close()
+	stdout, stderr, code := env.run(nil, "bundle", "install", bundle)
+	if code != 0 {
+		t.Fatalf("Expected exit code 0 for bundle install, actual %d: %s", code, stderr)
+	}
+	for _, ident := range []string{"foo/tool/1.2.0/20170524100002", "bar/lib/2.0.0/20170524100003"} {
+		if !strings.Contains(stdout, "Installed "+ident+"\n") {
+			t.Errorf("Expected %s to be reported as installed, actual %q", ident, stdout)
+		}
+	}
+	for _, key := range []string{"foo-20170101000000.pub", "bar-20170101000000.pub"} {
+		if _, err := os.Stat(filepath.Join(env.root, "hab", "cache", "keys", key)); err != nil {
+			t.Errorf("Expected the origin key %s to be imported: %v", key, err)
+		}
+	}
+
+	stdout, stderr, code = env.run(nil, "exec", "--pkg-version", "1.2.0", "foo/tool", "tool")
+	if code != 0 || stdout != "foo/tool/1.2.0/20170524100002\n" {
+		t.Errorf("Expected foo/tool/1.2.0 from the bundle, actual %q with exit code %d: %s", stdout, code, stderr)
+	}
+}
+
+func TestE2EMatrix(t *testing.T) {
+	packages := []hab.PackageInfo{
+		habtest.Package("foo/tool/8.0.0/20170524100001", "stable"),
+		habtest.Package("foo/tool/8.1.0/20170524100002", "stable"),
+		habtest.Package("foo/tool/8.1.2/20170524100003", "stable"),
+		habtest.Package("foo/tool/9.0.0/20170524100004", "stable"),
+		habtest.Package("foo/tool/10.0.0/20170524100005", "stable"),
+	}
+	env := newE2EEnv(t, packages, packages)
+
+	stdout, stderr, code := env.run(nil, "matrix", "foo/tool", "--pkg-version", ">=8 <10", "--select", "latest-minor",
+		"--parallel", "2", "--", "sh -c '! tool | grep -q /8.1.2/'")
+	if code != 1 {
+		t.Errorf("Expected exit code 1, actual %d: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "1 of 3 versions failed") {
+		t.Errorf("Expected the number of failures in stderr, actual %q", stderr)
+	}
+
+	summary := stdout[strings.Index(stdout, "PACKAGE"):]
+	var results []string
+	for _, line := range strings.Split(strings.TrimSpace(summary), "\n")[1:] {
+		fields := strings.Fields(line)
+		results = append(results, fields[0]+" "+fields[1])
+	}
+	expected := []string{"foo/tool/8.0.0 pass", "foo/tool/8.1.2 FAIL", "foo/tool/9.0.0 pass"}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("Expected results %q, actual %q in %s", expected, results, stdout)
+	}
+}
+
+func TestE2EInterruptDepotRequest(t *testing.T) {
+	env := newE2EEnv(t, []hab.PackageInfo{habtest.Package("foo/tool/1.0.0/20170524100001", "stable")}, nil)
+	env.depot.SetLatency(10 * time.Second)
+
+	cmd := exec.Command(env.sdStep, "resolve", "--depot-url", env.depot.DepotURL(), "--hab-path", env.hab,
+		"--hab-root", env.root, "--pkg-version", "^1.0.0", "foo/tool")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Unable to start sd-step: %v", err)
+	}
+	// the depot has received the request when it is interrupted
+	for len(env.depot.Requests()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	start := time.Now()
+	cmd.Process.Signal(syscall.SIGINT)
+
+	err := cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 130 {
+		t.Errorf("Expected exit code 130, actual %v: %s", err, stderr.String())
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the depot request to be cancelled, actual %v elapsed", elapsed)
+	}
+	if strings.Contains(stderr.String(), "Trying to fetch versions") {
+		t.Errorf("Expected no fallback after the interrupt, actual %q", stderr.String())
+	}
+}
+
+func TestE2EInfo(t *testing.T) {
+	packages := []hab.PackageInfo{
+		habtest.Package("foo/tool/1.0.0/20170524100001", "stable"),
+		habtest.Package("foo/tool/1.2.0/20170524100002", "stable"),
+		habtest.Package("foo/tool/2.0.0/20170524100003", "unstable"),
+	}
+	env := newE2EEnv(t, packages, packages)
+
+	info := func(args ...string) step.Info {
+		stdout, stderr, code := env.run(nil, append([]string{"info", "--json"}, args...)...)
+		if code != 0 {
+			t.Fatalf("Expected exit code 0, actual %d: %s", code, stderr)
+		}
+		var info step.Info
+		if err := json.Unmarshal([]byte(stdout), &info); err != nil {
+			t.Fatalf("Unable to parse %q: %v", stdout, err)
+		}
+		return info
+	}
+
+	latest := info("foo/tool")
+	if latest.Ident().String() != "foo/tool/1.2.0/20170524100002" || latest.Installed {
+		t.Errorf("Expected foo/tool/1.2.0 which is not installed, actual %+v", latest)
+	}
+	if latest.Target != "x86_64-linux" || latest.Checksum == "" || latest.BuildDate != "2017-05-24T10:00:02Z" {
+		t.Errorf("Expected the detail from the depot, actual %+v", latest)
+	}
+
+	if _, stderr, code := env.run(nil, "exec", "--pkg-version", "^1.0.0", "foo/tool", "tool"); code != 0 {
+		t.Fatalf("Expected exit code 0, actual %d: %s", code, stderr)
+	}
+	installed := info("--pkg-version", "^1.0.0", "foo/tool")
+	expectedPath := filepath.Join(env.root, "hab", "pkgs", "foo", "tool", "1.2.0", "20170524100002")
+	if !installed.Installed || installed.Path != expectedPath || installed.Size == 0 {
+		t.Errorf("Expected foo/tool/1.2.0 to be installed in %s, actual %+v", expectedPath, installed)
+	}
+
+	stdout, stderr, code := env.run(nil, "info", "--hab-channel", "unstable", "foo/tool/2.0.0")
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, actual %d: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "foo/tool/2.0.0/20170524100003") || !strings.Contains(stdout, "Installed:    no") {
+		t.Errorf("Expected foo/tool/2.0.0 which is not installed, actual %q", stdout)
+	}
+
+	if _, stderr, code := env.run(nil, "info", "foo/missing"); code != 4 {
+		t.Errorf("Expected exit code 4 for a missing package, actual %d: %s", code, stderr)
+	}
+}
+
+func TestE2ESearch(t *testing.T) {
+	env := newE2EEnv(t, []hab.PackageInfo{
+		habtest.Package("core/tool/1.0.0/20170524100001", "stable"),
+		habtest.Package("core/tool/2.0.0/20170524100002", "unstable"),
+		habtest.Package("foo/tool/1.2.0/20170524100003", "stable"),
+		habtest.Package("foo/toolbox/0.1.0/20170524100004", "unstable"),
+	}, nil)
+
+	stdout, stderr, code := env.run(nil, "search", "tool")
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, actual %d: %s", code, stderr)
+	}
+	expected := "PACKAGE      VERSION  RELEASE\n" +
+		"core/tool    1.0.0    20170524100001\n" +
+		"foo/tool     1.2.0    20170524100003\n" +
+		"foo/toolbox  -        -\n"
+	if stdout != expected {
+		t.Errorf("Expected %q, actual %q", expected, stdout)
+	}
+
+	stdout, stderr, code = env.run(nil, "search", "--origin", "foo", "--channel", "unstable", "tool")
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, actual %d: %s", code, stderr)
+	}
+	expected = "PACKAGE      VERSION  RELEASE\n" +
+		"foo/tool     -        -\n" +
+		"foo/toolbox  0.1.0    20170524100004\n"
+	if stdout != expected {
+		t.Errorf("Expected %q, actual %q", expected, stdout)
+	}
+
+	stdout, stderr, code = env.run(nil, "search", "missing")
+	if code != 0 || stdout != "" || !strings.Contains(stderr, "No packages found for missing") {
+		t.Errorf("Expected no packages, actual %q with exit code %d: %s", stdout, code, stderr)
+	}
+}
+
+func TestE2ETarget(t *testing.T) {
+	onTarget := func(ident, target string) hab.PackageInfo {
+		pkg := habtest.Package(ident, "stable")
+		pkg.Target = target
+		return pkg
+	}
+	packages := []hab.PackageInfo{
+		onTarget("foo/tool/1.0.0/20170524100001", "x86_64-linux"),
+		onTarget("foo/tool/1.0.0/20170524100002", "aarch64-linux"),
+		onTarget("foo/tool/1.1.0/20170524100003", "x86_64-linux"),
+	}
+	env := newE2EEnv(t, packages, packages)
+
+	// the version which only exists for x86_64-linux is not picked on aarch64-linux
+	stdout, stderr, code := env.run([]string{"HAB_TARGET=aarch64-linux"}, "exec", "--pkg-version", "^1.0.0", "foo/tool", "tool")
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, actual %d: %s", code, stderr)
+	}
+	if expected := "foo/tool/1.0.0/20170524100002\n"; stdout != expected {
+		t.Errorf("Expected %q, actual %q", expected, stdout)
+	}
+
+	stdout, stderr, code = env.run(nil, "resolve", "--target", "x86_64-linux", "--pkg-version", "^1.0.0", "foo/tool")
+	if code != 0 || stdout != "foo/tool/1.1.0\n" {
+		t.Errorf("Expected foo/tool/1.1.0 on x86_64-linux, actual %q with exit code %d: %s", stdout, code, stderr)
+	}
+
+	_, stderr, code = env.run(nil, "resolve", "--target", "aarch64-linux", "--pkg-version", "1.1.0", "foo/tool")
+	if code != 3 {
+		t.Errorf("Expected exit code 3 for a version on another target, actual %d: %s", code, stderr)
+	}
+
+	_, stderr, code = env.run(nil, "resolve", "--target", "sparc-solaris", "foo/tool")
+	if code != 1 || !strings.Contains(stderr, "sparc-solaris is invalid target") {
+		t.Errorf("Expected invalid target, actual exit code %d: %s", code, stderr)
+	}
+}
@@ -0,0 +1,158 @@
+package hab
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type cachingDepot struct {
+	depot  Depot
+	dir    string
+	target string
+}
+
+// NewCachingDepot returns a depot which saves the packages listed by depot into dir for NewCacheDepot.
+func NewCachingDepot(depot Depot, dir string, opts ...Option) ContextDepot {
+	return &cachingDepot{depot, dir, newOptions(opts).target}
+}
+
+// PackagesFromName fetches all releases in habChannel from the depot and saves them.
+func (depo *cachingDepot) PackagesFromName(pkgName string, habChannel string) ([]PackageInfo, error) {
+	return depo.PackagesFromNameContext(context.Background(), pkgName, habChannel)
+}
+
+// PackagesFromNameContext fetches all releases in habChannel from the depot until ctx is done,
+// and saves them.
+func (depo *cachingDepot) PackagesFromNameContext(ctx context.Context, pkgName string, habChannel string) ([]PackageInfo, error) {
+	packages, err := PackagesFromNameContext(ctx, depo.depot, pkgName, habChannel)
+	if err != nil {
+		return nil, err
+	}
+	// the releases in every channel are saved by each channel
+	if habChannel != "" {
+		if path, err := cacheFile(depo.dir, depo.target, pkgName, habChannel); err == nil {
+			writeCacheFile(path, packages)
+		}
+	}
+	return packages, nil
+}
+
+// PackageVersionsFromName fetches all versions in habChannel from the depot and saves them.
+func (depo *cachingDepot) PackageVersionsFromName(pkgName string, habChannel string) ([]string, error) {
+	return depo.PackageVersionsFromNameContext(context.Background(), pkgName, habChannel)
+}
+
+// PackageVersionsFromNameContext fetches all versions in habChannel from the depot until ctx is
+// done, and saves them.
+func (depo *cachingDepot) PackageVersionsFromNameContext(ctx context.Context, pkgName string, habChannel string) ([]string, error) {
+	packages, err := depo.PackagesFromNameContext(ctx, pkgName, habChannel)
+	if err != nil {
+		return nil, err
+	}
+	return uniqueVersions(packages), nil
+}
+
+type cacheDepot struct {
+	dir    string
+	target string
+}
+
+// NewCacheDepot returns a depot which lists the packages saved into dir by NewCachingDepot for
+// the target of opts.
+func NewCacheDepot(dir string, opts ...Option) ListingDepot {
+	return &cacheDepot{dir, newOptions(opts).target}
+}
+
+// PackagesFromName returns all releases in habChannel which were saved last time. An empty
+// habChannel returns the releases saved for every channel.
+func (depo *cacheDepot) PackagesFromName(pkgName string, habChannel string) ([]PackageInfo, error) {
+	path, err := cacheFile(depo.dir, depo.target, pkgName, habChannel)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{path}
+	if habChannel == "" {
+		paths, _ = filepath.Glob(filepath.Join(filepath.Dir(path), "*.json"))
+		sort.Strings(paths)
+	}
+
+	var packages []PackageInfo
+	found := map[string]bool{}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var cached []PackageInfo
+		if err := json.Unmarshal(data, &cached); err != nil {
+			return nil, err
+		}
+		for _, pkg := range cached {
+			if ident := pkg.Ident().String(); !found[ident] && onTarget(pkg, depo.target) {
+				found[ident] = true
+				packages = append(packages, pkg)
+			}
+		}
+	}
+
+	if len(packages) == 0 {
+		return nil, ErrPackageNotFound
+	}
+	return packages, nil
+}
+
+// PackageVersionsFromName returns all versions in habChannel which were saved last time.
+func (depo *cacheDepot) PackageVersionsFromName(pkgName string, habChannel string) ([]string, error) {
+	packages, err := depo.PackagesFromName(pkgName, habChannel)
+	if err != nil {
+		return nil, err
+	}
+	return uniqueVersions(packages), nil
+}
+
+// cacheFile returns the path of the releases of pkgName in habChannel on target under dir, which
+// is target/origin/name/channel.json, with "any" for an empty target.
+func cacheFile(dir, target, pkgName, habChannel string) (string, error) {
+	ident, err := ParseIdent(pkgName)
+	if err != nil || ident.Version != "" || strings.ContainsAny(habChannel+target, `/\`) {
+		return "", ErrPackageNotFound
+	}
+	if target == "" {
+		target = "any"
+	}
+	return filepath.Join(dir, target, ident.Origin, ident.Name, habChannel+".json"), nil
+}
+
+// writeCacheFile replaces the file at path with packages, so that a reader never sees a part of it.
+func writeCacheFile(path string, packages []PackageInfo) error {
+	data, err := json.Marshal(packages)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".cache")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,105 @@
+package hab
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// channelDepot has releases in each channel, or fails with err.
+type channelDepot struct {
+	packages map[string][]PackageInfo
+	err      error
+}
+
+func (depo *channelDepot) PackagesFromName(pkgName string, habChannel string) ([]PackageInfo, error) {
+	if depo.err != nil {
+		return nil, depo.err
+	}
+	return depo.packages[habChannel], nil
+}
+
+func (depo *channelDepot) PackageVersionsFromName(pkgName string, habChannel string) ([]string, error) {
+	packages, err := depo.PackagesFromName(pkgName, habChannel)
+	return uniqueVersions(packages), err
+}
+
+func TestCacheDepot(t *testing.T) {
+	stable := []PackageInfo{
+		{Origin: "foo", Name: "test", Version: "0.0.1", Release: "20170524100001", Channels: []string{"stable", "unstable"}},
+	}
+	unstable := []PackageInfo{
+		{Origin: "foo", Name: "test", Version: "0.0.1", Release: "20170524100001", Channels: []string{"stable", "unstable"}},
+		{Origin: "foo", Name: "test", Version: "0.0.2", Release: "20170524100002", Channels: []string{"unstable"}},
+	}
+	depot := &channelDepot{packages: map[string][]PackageInfo{"stable": stable, "unstable": unstable}}
+	dir := t.TempDir()
+	caching := NewCachingDepot(depot, dir)
+	cache := NewCacheDepot(dir)
+
+	if _, err := cache.PackagesFromName("foo/test", "stable"); !errors.Is(err, ErrPackageNotFound) {
+		t.Errorf("Expected ErrPackageNotFound before caching, actual %v", err)
+	}
+
+	for _, channel := range []string{"stable", "unstable"} {
+		if _, err := caching.PackagesFromName("foo/test", channel); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	// the cache is kept while the depot fails
+	depot.err = errors.New("depot error")
+	if _, err := caching.PackagesFromName("foo/test", "stable"); err == nil {
+		t.Errorf("Expected error of the depot, actual nil")
+	}
+
+	tests := []struct {
+		pkgName     string
+		channel     string
+		expected    []string
+		expectError bool
+	}{
+		{"foo/test", "stable", []string{"0.0.1"}, false},
+		{"foo/test", "unstable", []string{"0.0.1", "0.0.2"}, false},
+		{"foo/test", "", []string{"0.0.1", "0.0.2"}, false},
+		{"foo/test", "nightly", nil, true},
+		{"foo/other", "stable", nil, true},
+		{"foo/test/0.0.1", "stable", nil, true},
+	}
+
+	for _, test := range tests {
+		versions, err := cache.PackageVersionsFromName(test.pkgName, test.channel)
+		if test.expectError {
+			if !errors.Is(err, ErrPackageNotFound) {
+				t.Errorf("Expected ErrPackageNotFound for %s in %q, actual %v", test.pkgName, test.channel, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(versions, test.expected) {
+			t.Errorf("Expected versions %v of %s in %q, actual %v", test.expected, test.pkgName, test.channel, versions)
+		}
+	}
+}
+
+func TestCacheDepotTarget(t *testing.T) {
+	packages := []PackageInfo{{Origin: "foo", Name: "test", Version: "0.0.1", Release: "20170524100001"}}
+	dir := t.TempDir()
+	caching := NewCachingDepot(&channelDepot{packages: map[string][]PackageInfo{"stable": packages}}, dir, WithTarget("aarch64-linux"))
+	if _, err := caching.PackagesFromName("foo/test", "stable"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "aarch64-linux", "foo", "test", "stable.json")); err != nil {
+		t.Errorf("Expected the releases to be saved for the target: %v", err)
+	}
+	if _, err := NewCacheDepot(dir, WithTarget("x86_64-linux")).PackagesFromName("foo/test", "stable"); !errors.Is(err, ErrPackageNotFound) {
+		t.Errorf("Expected ErrPackageNotFound on another target, actual %v", err)
+	}
+	if versions, err := NewCacheDepot(dir, WithTarget("aarch64-linux")).PackageVersionsFromName("foo/test", "stable"); err != nil || !reflect.DeepEqual(versions, []string{"0.0.1"}) {
+		t.Errorf("Expected [0.0.1] on the target, actual %v (%v)", versions, err)
+	}
+}
@@ -0,0 +1,118 @@
+package hab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrOriginNotFound is returned when a depot has no origin of the name.
+var ErrOriginNotFound = errors.New("origin not found")
+
+// releaseLayout is the layout of releases, which are the UTC timestamps of builds.
+const releaseLayout = "20060102150405"
+
+// PackageDetail is the metadata of a release of a package.
+type PackageDetail struct {
+	Ident    PackageIdent   `json:"ident"`
+	Checksum string         `json:"checksum"`
+	Manifest string         `json:"manifest"`
+	Target   string         `json:"target"`
+	Deps     []PackageIdent `json:"deps"`
+	TDeps    []PackageIdent `json:"tdeps"`
+	Exposes  []int          `json:"exposes"`
+	Config   string         `json:"config"`
+	Channels []string       `json:"channels"`
+}
+
+// BuildDate returns the time when the package was built, which its release tells.
+func (d PackageDetail) BuildDate() (time.Time, error) {
+	return time.Parse(releaseLayout, d.Ident.Release)
+}
+
+// MetadataDepot is a Depot which also tells the details of packages and the channels of origins.
+type MetadataDepot interface {
+	Depot
+	// PackageDetail returns the detail of the release which pkgName identifies, or of the latest
+	// release matching pkgName if it is not fully qualified.
+	PackageDetail(ctx context.Context, pkgName string) (PackageDetail, error)
+	// LatestInChannel returns the detail of the latest release of pkgName in habChannel.
+	// pkgName may have a version to find the latest release of it.
+	LatestInChannel(ctx context.Context, pkgName string, habChannel string) (PackageDetail, error)
+	// OriginChannels returns the names of the channels of origin.
+	OriginChannels(ctx context.Context, origin string) ([]string, error)
+}
+
+// Client is the depot API client, which lists packages with a context, tells their details and
+// searches them.
+type Client interface {
+	ContextDepot
+	MetadataDepot
+	Searcher
+}
+
+// PackageDetail fetches the detail of the release which pkgName identifies from depot.
+func (depo *depot) PackageDetail(ctx context.Context, pkgName string) (PackageDetail, error) {
+	ident, err := ParseIdent(pkgName)
+	if err != nil {
+		return PackageDetail{}, err
+	}
+
+	path := "pkgs/" + ident.String()
+	if ident.Release == "" {
+		path += "/latest"
+	}
+	var detail PackageDetail
+	err = depo.get(ctx, depo.targetQuery(path), ErrPackageNotFound, &detail)
+	return detail, err
+}
+
+// LatestInChannel fetches the detail of the latest release of pkgName in habChannel from depot.
+func (depo *depot) LatestInChannel(ctx context.Context, pkgName string, habChannel string) (PackageDetail, error) {
+	ident, err := ParseIdent(pkgName)
+	if err != nil {
+		return PackageDetail{}, err
+	}
+	if ident.Release != "" {
+		return PackageDetail{}, fmt.Errorf("%v already specifies the release", pkgName)
+	}
+
+	path := fmt.Sprintf("channels/%s/%s/pkgs/%s", ident.Origin, habChannel, ident.Name)
+	if ident.Version != "" {
+		path += "/" + ident.Version
+	}
+	var detail PackageDetail
+	err = depo.get(ctx, depo.targetQuery(path+"/latest"), ErrPackageNotFound, &detail)
+	return detail, err
+}
+
+// OriginChannels fetches the names of the channels of origin from depot.
+func (depo *depot) OriginChannels(ctx context.Context, origin string) ([]string, error) {
+	var channels []struct {
+		Name string `json:"name"`
+	}
+	if err := depo.get(ctx, "channels/"+origin, ErrOriginNotFound, &channels); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, channel := range channels {
+		names = append(names, channel.Name)
+	}
+	return names, nil
+}
+
+// latest returns the latest of packages, which is the highest version and the latest release of it.
+func latest(packages []PackageInfo) (PackageInfo, bool) {
+	if len(packages) == 0 {
+		return PackageInfo{}, false
+	}
+	latest := packages[0]
+	for _, pkg := range packages[1:] {
+		if pkg.Ident().Compare(latest.Ident()) > 0 {
+			latest = pkg
+		}
+	}
+	return latest, true
+}
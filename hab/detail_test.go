@@ -0,0 +1,112 @@
+package hab
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPackageDetailBuildDate(t *testing.T) {
+	detail := PackageDetail{Ident: PackageIdent{"foo", "test", "0.0.1", "20170524100001"}}
+	date, err := detail.BuildDate()
+	if err != nil {
+		t.Fatalf("BuildDate error = %v, should be nil", err)
+	}
+	if expected := time.Date(2017, 5, 24, 10, 0, 1, 0, time.UTC); !date.Equal(expected) {
+		t.Errorf("Expected %v, actual %v", expected, date)
+	}
+
+	detail.Ident.Release = ""
+	if _, err := detail.BuildDate(); err == nil {
+		t.Errorf("Expected error for an empty release")
+	}
+}
+
+func TestDirDepotPackageDetail(t *testing.T) {
+	dir := t.TempDir()
+	release := filepath.Join(dir, "foo", "test", "0.0.2", "20170524100002")
+	writeTestFile(t, filepath.Join(release, "MANIFEST"), "# foo/test\n")
+	writeTestFile(t, filepath.Join(release, "TARGET"), "x86_64-linux\n")
+	writeTestFile(t, filepath.Join(release, "DEPS"), "core/glibc/2.22/20170513215607\n")
+	writeTestFile(t, filepath.Join(release, "TDEPS"), "core/glibc/2.22/20170513215607\ncore/linux-headers/4.3/20170513200956\n")
+	writeTestFile(t, filepath.Join(release, "EXPOSES"), "8080 8443")
+	writeTestFile(t, filepath.Join(release, "default.toml"), "port = 8080\n")
+	writeTestFile(t, filepath.Join(dir, "foo", "test", "0.0.1", "20170524100001", "MANIFEST"), "")
+	writeTestFile(t, filepath.Join(dir, "foo", "test", "0.0.3", "20170524100003", "MANIFEST"), "")
+	writeTestFile(t, filepath.Join(dir, channelsFileName), `{
+		"foo/test/0.0.1/20170524100001": ["stable"],
+		"foo/test/0.0.2/20170524100002": ["stable", "unstable"],
+		"foo/test/0.0.3/20170524100003": ["unstable"]
+	}`)
+
+	depot := NewDirDepot(dir)
+	ctx := context.Background()
+
+	detail, err := depot.PackageDetail(ctx, "foo/test/0.0.2")
+	if err != nil {
+		t.Fatalf("PackageDetail error = %v, should be nil", err)
+	}
+	expected := PackageDetail{
+		Ident:    PackageIdent{"foo", "test", "0.0.2", "20170524100002"},
+		Manifest: "# foo/test\n",
+		Target:   "x86_64-linux",
+		Deps:     []PackageIdent{{"core", "glibc", "2.22", "20170513215607"}},
+		TDeps: []PackageIdent{
+			{"core", "glibc", "2.22", "20170513215607"},
+			{"core", "linux-headers", "4.3", "20170513200956"},
+		},
+		Exposes:  []int{8080, 8443},
+		Config:   "port = 8080\n",
+		Channels: []string{"stable", "unstable"},
+	}
+	if !reflect.DeepEqual(detail, expected) {
+		t.Errorf("Expected %+v, actual %+v", expected, detail)
+	}
+
+	tests := []struct {
+		pkgName  string
+		channel  string
+		expected string
+	}{
+		{"foo/test", "", "foo/test/0.0.3/20170524100003"},
+		{"foo/test", "stable", "foo/test/0.0.2/20170524100002"},
+		{"foo/test/0.0.1", "stable", "foo/test/0.0.1/20170524100001"},
+	}
+	for _, test := range tests {
+		var detail PackageDetail
+		var err error
+		if test.channel == "" {
+			detail, err = depot.PackageDetail(ctx, test.pkgName)
+		} else {
+			detail, err = depot.LatestInChannel(ctx, test.pkgName, test.channel)
+		}
+		if err != nil {
+			t.Errorf("%s in %q: error = %v, should be nil", test.pkgName, test.channel, err)
+			continue
+		}
+		if ident := detail.Ident.String(); ident != test.expected {
+			t.Errorf("%s in %q: expected %v, actual %v", test.pkgName, test.channel, test.expected, ident)
+		}
+	}
+
+	if _, err := depot.LatestInChannel(ctx, "foo/test/0.0.3", "stable"); !errors.Is(err, ErrPackageNotFound) {
+		t.Errorf("Expected ErrPackageNotFound, actual %v", err)
+	}
+	if _, err := depot.LatestInChannel(ctx, "foo/test/0.0.1/20170524100001", "stable"); err == nil {
+		t.Errorf("Expected error for a fully qualified ident")
+	}
+
+	channels, err := depot.OriginChannels(ctx, "foo")
+	if err != nil {
+		t.Fatalf("OriginChannels error = %v, should be nil", err)
+	}
+	if expected := []string{"stable", "unstable"}; !reflect.DeepEqual(channels, expected) {
+		t.Errorf("Expected %v, actual %v", expected, channels)
+	}
+	if _, err := depot.OriginChannels(ctx, "bar"); !errors.Is(err, ErrOriginNotFound) {
+		t.Errorf("Expected ErrOriginNotFound, actual %v", err)
+	}
+}
@@ -0,0 +1,406 @@
+package hab
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// indexFileName is the index of packages in a directory depot.
+const indexFileName = "index.json"
+
+// channelsFileName is the sidecar file which maps fully qualified idents to their channels.
+const channelsFileName = "channels.json"
+
+type dirDepot struct {
+	dir    string
+	target string
+}
+
+// NewDirDepot returns a depot which reads an index.json, .hart files or installed packages in dir.
+func NewDirDepot(dir string, opts ...Option) MetadataDepot {
+	return &dirDepot{dir: dir, target: newOptions(opts).target}
+}
+
+// Open returns a directory depot for a file:// depotURL, or the depot API configured with opts.
+func Open(depotURL string, opts ...Option) (Depot, error) {
+	u, err := url.Parse(depotURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "file" {
+		if u.Path == "" {
+			return nil, errors.New("file depot URL must have a path")
+		}
+		return NewDirDepot(filepath.FromSlash(u.Path), opts...), nil
+	}
+	return New(depotURL, opts...), nil
+}
+
+// packages returns all packages of pkgName in the directory.
+func (depo *dirDepot) packages(pkgName string) ([]PackageInfo, error) {
+	ident, err := ParseIdent(pkgName)
+	if err != nil || ident.Version != "" {
+		return nil, ErrPackageNotFound
+	}
+	origin, name := ident.Origin, ident.Name
+
+	var packages []PackageInfo
+
+	index, err := ioutil.ReadFile(filepath.Join(depo.dir, indexFileName))
+	if err == nil {
+		var indexed []PackageInfo
+		if err := json.Unmarshal(index, &indexed); err != nil {
+			return nil, err
+		}
+		for _, pkg := range indexed {
+			if pkg.Origin == origin && pkg.Name == name {
+				packages = append(packages, pkg)
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	} else {
+		packages = append(packages, installedPackages(depo.dir, origin, name)...)
+		packages = append(packages, hartPackages(depo.dir, origin, name)...)
+	}
+
+	if len(packages) == 0 {
+		return nil, ErrPackageNotFound
+	}
+
+	channels, err := depo.channels()
+	if err != nil {
+		return nil, err
+	}
+	for i, pkg := range packages {
+		if c, ok := channels[pkg.Ident().String()]; ok {
+			packages[i].Channels = c
+		}
+	}
+
+	return packages, nil
+}
+
+// channels reads the channels sidecar file.
+func (depo *dirDepot) channels() (map[string][]string, error) {
+	channels := map[string][]string{}
+
+	data, err := ioutil.ReadFile(filepath.Join(depo.dir, channelsFileName))
+	if os.IsNotExist(err) {
+		return channels, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &channels)
+	return channels, err
+}
+
+// installedPackages returns the packages laid out as origin/name/version/release under dir.
+func installedPackages(dir, origin, name string) []PackageInfo {
+	var packages []PackageInfo
+
+	releaseDirs, _ := filepath.Glob(filepath.Join(dir, origin, name, "*", "*"))
+	for _, releaseDir := range releaseDirs {
+		if info, err := os.Stat(releaseDir); err != nil || !info.IsDir() {
+			continue
+		}
+		target, _ := ioutil.ReadFile(filepath.Join(releaseDir, "TARGET"))
+		packages = append(packages, PackageInfo{
+			Origin:  origin,
+			Name:    name,
+			Version: filepath.Base(filepath.Dir(releaseDir)),
+			Release: filepath.Base(releaseDir),
+			Target:  strings.TrimSpace(string(target)),
+		})
+	}
+
+	return packages
+}
+
+// hartPackages returns the packages of the origin-name-version-release-target.hart files in dir.
+func hartPackages(dir, origin, name string) []PackageInfo {
+	var packages []PackageInfo
+
+	prefix := origin + "-" + name + "-"
+	harts, _ := filepath.Glob(filepath.Join(dir, prefix+"*.hart"))
+	for _, hart := range harts {
+		rest := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(hart), prefix), ".hart")
+
+		var hartTarget string
+		for _, target := range Targets {
+			if strings.HasSuffix(rest, "-"+target) {
+				rest, hartTarget = strings.TrimSuffix(rest, "-"+target), target
+				break
+			}
+		}
+
+		i := strings.LastIndex(rest, "-")
+		if i <= 0 {
+			continue
+		}
+		version, release := rest[:i], rest[i+1:]
+		// the name of another package can start with this one, like core-node-js
+		if !releaseValidator.MatchString(release) || version[0] < '0' || version[0] > '9' {
+			continue
+		}
+
+		packages = append(packages, PackageInfo{
+			Origin:  origin,
+			Name:    name,
+			Version: version,
+			Release: release,
+			Target:  hartTarget,
+		})
+	}
+
+	return packages
+}
+
+// hartNames returns the origin/name of the packages of the .hart files in dir.
+func hartNames(dir string) []PackageIdent {
+	var idents []PackageIdent
+
+	harts, _ := filepath.Glob(filepath.Join(dir, "*.hart"))
+	for _, hart := range harts {
+		// the origin is the first part, and the name ends before the version which starts with a digit
+		parts := strings.Split(strings.TrimSuffix(filepath.Base(hart), ".hart"), "-")
+		for i := 2; i < len(parts); i++ {
+			if parts[i] != "" && parts[i][0] >= '0' && parts[i][0] <= '9' {
+				idents = append(idents, PackageIdent{Origin: parts[0], Name: strings.Join(parts[1:i], "-")})
+				break
+			}
+		}
+	}
+
+	return idents
+}
+
+// PackagesFromName returns all releases in habChannel in the directory. An empty habChannel
+// returns the releases in every channel.
+func (depo *dirDepot) PackagesFromName(pkgName string, habChannel string) ([]PackageInfo, error) {
+	packages, err := depo.packages(pkgName)
+	if err != nil {
+		return nil, err
+	}
+
+	var inHabChannel []PackageInfo
+	for _, pkg := range packages {
+		if onTarget(pkg, depo.target) && (habChannel == "" || inChannel(pkg, habChannel)) {
+			inHabChannel = append(inHabChannel, pkg)
+		}
+	}
+
+	return inHabChannel, nil
+}
+
+// PackageVersionsFromName returns all versions in the directory.
+func (depo *dirDepot) PackageVersionsFromName(pkgName string, habChannel string) ([]string, error) {
+	packages, err := depo.PackagesFromName(pkgName, habChannel)
+	if err != nil {
+		return nil, err
+	}
+	return uniqueVersions(packages), nil
+}
+
+// inChannel checks if pkg is in habChannel. A package without channels is in every channel.
+func inChannel(pkg PackageInfo, habChannel string) bool {
+	if len(pkg.Channels) == 0 {
+		return true
+	}
+	for _, channel := range pkg.Channels {
+		if channel == habChannel {
+			return true
+		}
+	}
+	return false
+}
+
+// PackageDetail returns the detail of the release which pkgName identifies in the directory.
+// Only the releases laid out like /hab/pkgs have the metadata other than the ident and channels.
+func (depo *dirDepot) PackageDetail(ctx context.Context, pkgName string) (PackageDetail, error) {
+	return depo.latestDetail(ctx, pkgName, "")
+}
+
+// LatestInChannel returns the detail of the latest release of pkgName in habChannel in the directory.
+func (depo *dirDepot) LatestInChannel(ctx context.Context, pkgName string, habChannel string) (PackageDetail, error) {
+	ident, err := ParseIdent(pkgName)
+	if err != nil {
+		return PackageDetail{}, err
+	}
+	if ident.Release != "" {
+		return PackageDetail{}, fmt.Errorf("%v already specifies the release", pkgName)
+	}
+	return depo.latestDetail(ctx, pkgName, habChannel)
+}
+
+// latestDetail returns the detail of the latest release matching pkgName in habChannel, or in
+// any channel if habChannel is empty.
+func (depo *dirDepot) latestDetail(ctx context.Context, pkgName string, habChannel string) (PackageDetail, error) {
+	if err := ctx.Err(); err != nil {
+		return PackageDetail{}, err
+	}
+	ident, err := ParseIdent(pkgName)
+	if err != nil {
+		return PackageDetail{}, err
+	}
+
+	packages, err := depo.PackagesFromName(ident.Package(), habChannel)
+	if err != nil {
+		return PackageDetail{}, err
+	}
+	var matched []PackageInfo
+	for _, pkg := range packages {
+		if (ident.Version == "" || pkg.Version == ident.Version) && (ident.Release == "" || pkg.Release == ident.Release) {
+			matched = append(matched, pkg)
+		}
+	}
+	pkg, ok := latest(matched)
+	if !ok {
+		return PackageDetail{}, ErrPackageNotFound
+	}
+
+	detail := PackageDetail{Ident: pkg.Ident(), Channels: pkg.Channels}
+	installedMetadata(filepath.Join(depo.dir, filepath.FromSlash(pkg.Ident().String())), &detail)
+	return detail, nil
+}
+
+// installedMetadata reads the metadata files of the package installed in dir into detail.
+func installedMetadata(dir string, detail *PackageDetail) {
+	read := func(name string) string {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, name))
+		return string(data)
+	}
+	readIdents := func(name string) []PackageIdent {
+		var idents []PackageIdent
+		for _, line := range strings.Fields(read(name)) {
+			if ident, err := ParseIdent(line); err == nil {
+				idents = append(idents, ident)
+			}
+		}
+		return idents
+	}
+
+	detail.Manifest = read("MANIFEST")
+	detail.Target = strings.TrimSpace(read("TARGET"))
+	detail.Deps = readIdents("DEPS")
+	detail.TDeps = readIdents("TDEPS")
+	detail.Config = read("default.toml")
+	for _, port := range strings.Fields(read("EXPOSES")) {
+		if n, err := strconv.Atoi(port); err == nil {
+			detail.Exposes = append(detail.Exposes, n)
+		}
+	}
+}
+
+// OriginChannels returns the names of the channels of origin in the index.json and
+// channels.json files of the directory.
+func (depo *dirDepot) OriginChannels(ctx context.Context, origin string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var lists [][]string
+	index, err := ioutil.ReadFile(filepath.Join(depo.dir, indexFileName))
+	if err == nil {
+		var indexed []PackageInfo
+		if err := json.Unmarshal(index, &indexed); err != nil {
+			return nil, err
+		}
+		for _, pkg := range indexed {
+			if pkg.Origin == origin {
+				lists = append(lists, pkg.Channels)
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	channels, err := depo.channels()
+	if err != nil {
+		return nil, err
+	}
+	for ident, c := range channels {
+		if strings.HasPrefix(ident, origin+"/") {
+			lists = append(lists, c)
+		}
+	}
+
+	found := map[string]bool{}
+	var names []string
+	for _, list := range lists {
+		for _, channel := range list {
+			if !found[channel] {
+				found[channel] = true
+				names = append(names, channel)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil, ErrOriginNotFound
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Search returns the packages whose origin/name contains term in the index.json, the
+// channels.json, the installed packages and the .hart files of the directory.
+func (depo *dirDepot) Search(ctx context.Context, term string) ([]PackageIdent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var packages []PackageInfo
+	index, err := ioutil.ReadFile(filepath.Join(depo.dir, indexFileName))
+	if err == nil {
+		if err := json.Unmarshal(index, &packages); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	} else {
+		nameDirs, _ := filepath.Glob(filepath.Join(depo.dir, "*", "*"))
+		for _, nameDir := range nameDirs {
+			if info, err := os.Stat(nameDir); err != nil || !info.IsDir() {
+				continue
+			}
+			origin, name := filepath.Base(filepath.Dir(nameDir)), filepath.Base(nameDir)
+			packages = append(packages, installedPackages(depo.dir, origin, name)...)
+		}
+		for _, ident := range hartNames(depo.dir) {
+			packages = append(packages, hartPackages(depo.dir, ident.Origin, ident.Name)...)
+		}
+	}
+
+	channels, err := depo.channels()
+	if err != nil {
+		return nil, err
+	}
+	for ident := range channels {
+		if ident, err := ParseIdent(ident); err == nil {
+			packages = append(packages, PackageInfo{Origin: ident.Origin, Name: ident.Name})
+		}
+	}
+
+	var matched []PackageInfo
+	for _, pkg := range packages {
+		if strings.Contains(pkg.Origin+"/"+pkg.Name, term) && onTarget(pkg, depo.target) {
+			matched = append(matched, pkg)
+		}
+	}
+	idents := uniquePackages(matched)
+	sort.Slice(idents, func(i, j int) bool {
+		return idents[i].String() < idents[j].String()
+	})
+	return idents, nil
+}
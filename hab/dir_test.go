@@ -0,0 +1,123 @@
+package hab
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, path string, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Unable to create directory: %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Unable to write %s: %v", path, err)
+	}
+}
+
+func TestDirDepotPackageVersionsFromName(t *testing.T) {
+	installedDir := t.TempDir()
+	for _, ident := range []string{"foo/test/0.0.1/20170524100001", "foo/test/0.0.1/20170524100002", "foo/test/0.1.0/20170524100003"} {
+		if err := os.MkdirAll(filepath.Join(installedDir, filepath.FromSlash(ident)), 0755); err != nil {
+			t.Fatalf("Unable to create package directory: %v", err)
+		}
+	}
+
+	hartDir := t.TempDir()
+	for _, hart := range []string{
+		"foo-test-0.0.1-20170524100001-x86_64-linux.hart",
+		"foo-test-0.0.2-20170524100002-x86_64-linux.hart",
+		"foo-test-1.0.0-rc1-20170524100003-aarch64-linux.hart",
+		"foo-test-tools-1.0.0-20170524100004-x86_64-linux.hart",
+	} {
+		writeTestFile(t, filepath.Join(hartDir, hart), "")
+	}
+	writeTestFile(t, filepath.Join(hartDir, channelsFileName), `{
+		"foo/test/0.0.1/20170524100001": ["unstable", "stable"],
+		"foo/test/0.0.2/20170524100002": ["unstable"]
+	}`)
+
+	indexDir := t.TempDir()
+	writeTestFile(t, filepath.Join(indexDir, indexFileName), `[
+		{"origin": "foo", "name": "test", "version": "0.0.1", "release": "20170524100001", "channels": ["stable"]},
+		{"origin": "foo", "name": "test", "version": "0.0.2", "release": "20170524100002", "channels": ["unstable"]},
+		{"origin": "foo", "name": "other", "version": "0.0.3", "release": "20170524100003", "channels": ["stable"]}
+	]`)
+
+	tests := []struct {
+		dir         string
+		channelName string
+		expected    []string
+		expectError bool
+	}{
+		{installedDir, "stable", []string{"0.0.1", "0.1.0"}, false},
+		{hartDir, "stable", []string{"0.0.1", "1.0.0-rc1"}, false},
+		{hartDir, "unstable", []string{"0.0.1", "0.0.2", "1.0.0-rc1"}, false},
+		{indexDir, "stable", []string{"0.0.1"}, false},
+		{indexDir, "unstable", []string{"0.0.2"}, false},
+		{indexDir, "", []string{"0.0.1", "0.0.2"}, false},
+		{t.TempDir(), "stable", nil, true},
+	}
+
+	for _, test := range tests {
+		results, err := NewDirDepot(test.dir).PackageVersionsFromName("foo/test", test.channelName)
+
+		if test.expectError {
+			if err == nil {
+				t.Errorf("Expected error, actual nil")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(results, test.expected) {
+			t.Errorf("Expected versions %v in %s, actual %v", test.expected, test.channelName, results)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		url         string
+		expected    Depot
+		expectError bool
+	}{
+		{"https://willem.habitat.sh/v1/depot", &depot{}, false},
+		{"file:///var/cache/hab", &dirDepot{dir: "/var/cache/hab"}, false},
+		{"file://", nil, true},
+		{"%zz", nil, true},
+	}
+
+	for _, test := range tests {
+		result, err := Open(test.url)
+
+		if test.expectError {
+			if err == nil {
+				t.Errorf("Expected error for %s, actual nil", test.url)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if reflect.TypeOf(result) != reflect.TypeOf(test.expected) {
+			t.Errorf("Expected %T for %s, actual %T", test.expected, test.url, result)
+		}
+		if dir, ok := test.expected.(*dirDepot); ok && !reflect.DeepEqual(result, dir) {
+			t.Errorf("Expected %v for %s, actual %v", dir, test.url, result)
+		}
+	}
+	// a directory depot only takes the target of the options
+	result, err := Open("file:///var/cache/hab", WithTimeout(time.Second), WithTarget("aarch64-linux"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := &dirDepot{dir: "/var/cache/hab", target: "aarch64-linux"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, actual %v", expected, result)
+	}
+}
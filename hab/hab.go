@@ -1,13 +1,51 @@
 package hab
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"sync"
 	"time"
 )
 
+var (
+	// ErrPackageNotFound is returned when a depot has no package of the name.
+	ErrPackageNotFound = errors.New("package not found")
+	// ErrDepotUnavailable is matched by the errors of requests to the depot which failed, such as
+	// network errors, unexpected status codes and malformed responses.
+	ErrDepotUnavailable = errors.New("depot unavailable")
+)
+
+// DepotError is an error of a request to the depot. It matches ErrDepotUnavailable with errors.Is.
+type DepotError struct {
+	// URL is the requested URL.
+	URL string
+	// StatusCode is the status code of the response, 0 if there is no response.
+	StatusCode int
+	// Err is the cause, nil if the response has an unexpected status code.
+	Err error
+}
+
+func (e *DepotError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+}
+
+// Unwrap returns the cause.
+func (e *DepotError) Unwrap() error {
+	return e.Err
+}
+
+// Is tells that the error is ErrDepotUnavailable.
+func (e *DepotError) Is(target error) bool {
+	return target == ErrDepotUnavailable
+}
+
 // PackagesInfo is response from depot.
 type PackagesInfo struct {
 	RangeStart  int           `json:"range_start"`
@@ -23,86 +61,344 @@ type PackageInfo struct {
 	Version  string   `json:"version"`
 	Release  string   `json:"release"`
 	Channels []string `json:"channels"`
+	// Target is the platform which the package is built for, such as x86_64-linux. It is empty
+	// when the depot does not tell it.
+	Target string `json:"target"`
 }
 
 // Depot for hab.
 type Depot interface {
+	// PackageVersionsFromName returns the versions of pkgName in habChannel.
 	PackageVersionsFromName(pkgName string, habChannel string) ([]string, error)
 }
 
+// ListingDepot is a Depot which also lists the releases of packages.
+type ListingDepot interface {
+	Depot
+	// PackagesFromName returns every release of pkgName in habChannel, or in any channel if
+	// habChannel is empty.
+	PackagesFromName(pkgName string, habChannel string) ([]PackageInfo, error)
+}
+
+// ContextDepot is a ListingDepot whose listings are cancelled when their context is done.
+type ContextDepot interface {
+	ListingDepot
+	// PackageVersionsFromNameContext returns the versions of pkgName in habChannel.
+	PackageVersionsFromNameContext(ctx context.Context, pkgName string, habChannel string) ([]string, error)
+	// PackagesFromNameContext returns every release of pkgName in habChannel, or in any channel
+	// if habChannel is empty.
+	PackagesFromNameContext(ctx context.Context, pkgName string, habChannel string) ([]PackageInfo, error)
+}
+
+// PackagesFromNameContext returns every release of pkgName in habChannel from depot until ctx is done.
+func PackagesFromNameContext(ctx context.Context, depot Depot, pkgName string, habChannel string) ([]PackageInfo, error) {
+	if d, ok := depot.(ContextDepot); ok {
+		return d.PackagesFromNameContext(ctx, pkgName, habChannel)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if d, ok := depot.(ListingDepot); ok {
+		return d.PackagesFromName(pkgName, habChannel)
+	}
+
+	ident, err := ParseIdent(pkgName)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := depot.PackageVersionsFromName(pkgName, habChannel)
+	if err != nil {
+		return nil, err
+	}
+	var channels []string
+	if habChannel != "" {
+		channels = []string{habChannel}
+	}
+	var packages []PackageInfo
+	for _, version := range versions {
+		packages = append(packages, PackageInfo{Origin: ident.Origin, Name: ident.Name, Version: version, Channels: channels})
+	}
+	return packages, nil
+}
+
+// PackageVersionsFromNameContext returns the versions of pkgName in habChannel from depot like
+// PackagesFromNameContext.
+func PackageVersionsFromNameContext(ctx context.Context, depot Depot, pkgName string, habChannel string) ([]string, error) {
+	if d, ok := depot.(ContextDepot); ok {
+		return d.PackageVersionsFromNameContext(ctx, pkgName, habChannel)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return depot.PackageVersionsFromName(pkgName, habChannel)
+}
+
+// DefaultTimeout is the time limit of each request to the depot.
+const DefaultTimeout = 20 * time.Second
+
 type depot struct {
-	baseURL string
-	client  *http.Client
+	baseURL   string
+	client    *http.Client
+	userAgent string
+	target    string
+}
+
+// options are what Option configures of a depot.
+type options struct {
+	timeout   time.Duration
+	transport http.RoundTripper
+	userAgent string
+	target    string
+}
+
+// newOptions returns the options which opts configure over the defaults.
+func newOptions(opts []Option) options {
+	o := options{timeout: DefaultTimeout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Option configures the depot client returned by New.
+type Option func(*options)
+
+// WithTimeout limits the time of each request to timeout, no limit if it is zero.
+// It is DefaultTimeout by default.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithTransport sends requests with transport instead of http.DefaultTransport.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(o *options) {
+		o.transport = transport
+	}
+}
+
+// WithUserAgent sends userAgent as the User-Agent header of requests.
+func WithUserAgent(userAgent string) Option {
+	return func(o *options) {
+		o.userAgent = userAgent
+	}
 }
 
 // New returns a new depot object.
-func New(baseURL string) Depot {
-	return &depot{baseURL, &http.Client{Timeout: 20 * time.Second}}
+func New(baseURL string, opts ...Option) Client {
+	o := newOptions(opts)
+	return &depot{
+		baseURL:   baseURL,
+		client:    &http.Client{Timeout: o.timeout, Transport: o.transport},
+		userAgent: o.userAgent,
+		target:    o.target,
+	}
 }
 
+// pageWorkers is the number of pages of a package listing which are fetched at the same time.
+const pageWorkers = 4
+
 // packagesInfo fetch packages info from depot
-func (depo *depot) packagesInfo(pkgName string, from int) (PackagesInfo, error) {
-	pkgURL := fmt.Sprintf("%s/pkgs/%s?range=%d", depo.baseURL, pkgName, from)
-	res, err := depo.client.Get(pkgURL)
+func (depo *depot) packagesInfo(ctx context.Context, pkgName string, from int) (PackagesInfo, error) {
+	path := fmt.Sprintf("pkgs/%s?range=%d", pkgName, from)
+	if depo.target != "" {
+		path += "&target=" + url.QueryEscape(depo.target)
+	}
+	var pkgsInfo PackagesInfo
+	err := depo.get(ctx, path, ErrPackageNotFound, &pkgsInfo)
+	return pkgsInfo, err
+}
+
+// get fetches path of the depot API into v. A 404 response returns notFound.
+func (depo *depot) get(ctx context.Context, path string, notFound error, v interface{}) error {
+	reqURL := depo.baseURL + "/" + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return err
+	}
+	if depo.userAgent != "" {
+		req.Header.Set("User-Agent", depo.userAgent)
+	}
+	res, err := depo.client.Do(req)
 
 	if err != nil {
-		return PackagesInfo{}, err
+		return &DepotError{URL: reqURL, Err: err}
 	}
 
 	defer res.Body.Close()
 
 	if res.StatusCode == 404 {
-		return PackagesInfo{}, errors.New("package not found")
+		return notFound
 	}
 	if res.StatusCode/100 != 2 {
-		return PackagesInfo{}, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+		return &DepotError{URL: reqURL, StatusCode: res.StatusCode}
 	}
 
-	var pkgsInfo PackagesInfo
-	err = json.NewDecoder(res.Body).Decode(&pkgsInfo)
-
-	if err != nil {
-		return PackagesInfo{}, err
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return &DepotError{URL: reqURL, StatusCode: res.StatusCode, Err: err}
 	}
 
-	return pkgsInfo, nil
+	return nil
 }
 
-// PackageVersionsFromName fetches all versions from depot.
-func (depo *depot) PackageVersionsFromName(pkgName string, habChannel string) ([]string, error) {
-	var packages []PackageInfo
+// pages fetches all pages of pkgName, the ones after the first concurrently.
+func (depo *depot) pages(ctx context.Context, pkgName string) ([]PackagesInfo, error) {
+	first, err := depo.packagesInfo(ctx, pkgName, 0)
+	if err != nil {
+		return nil, err
+	}
+	if first.TotalCount <= 0 {
+		return nil, nil
+	}
+	pages := []PackagesInfo{first}
 
-	offset := 0
-	for {
-		pkgsInfo, err := depo.packagesInfo(pkgName, offset)
+	if pageSize := first.RangeEnd - first.RangeStart + 1; pageSize > 0 {
+		var offsets []int
+		for offset := first.RangeEnd + 1; offset < first.TotalCount; offset += pageSize {
+			offsets = append(offsets, offset)
+		}
+		rest, err := depo.pagesAt(ctx, pkgName, offsets)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, rest...)
+	}
 
+	for last := pages[len(pages)-1]; last.TotalCount > 0; {
+		last, err = depo.packagesInfo(ctx, pkgName, last.RangeEnd+1)
 		if err != nil {
 			return nil, err
 		}
+		if last.TotalCount > 0 {
+			pages = append(pages, last)
+		}
+	}
+	return pages, nil
+}
+
+// pagesAt fetches the pages of pkgName at offsets in order with up to pageWorkers requests at a
+// time. The first error cancels the requests in flight and is returned.
+func (depo *depot) pagesAt(ctx context.Context, pkgName string, offsets []int) ([]PackagesInfo, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pages := make([]PackagesInfo, len(offsets))
+	var firstErr error
+	var once sync.Once
+	var wg sync.WaitGroup
+	jobs := make(chan int)
+	for w := 0; w < pageWorkers && w < len(offsets); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				page, err := depo.packagesInfo(ctx, pkgName, offsets[i])
+				if err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					continue
+				}
+				pages[i] = page
+			}
+		}()
+	}
+
+feed:
+	for i := range offsets {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
 
-		if pkgsInfo.TotalCount <= 0 {
-			break
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	// a page is empty when packages were removed after the first page
+	for i, page := range pages {
+		if page.TotalCount <= 0 {
+			return pages[:i], nil
 		}
+	}
+	return pages, nil
+}
 
-		packages = append(packages, pkgsInfo.PackageList...)
+// PackagesFromName fetches all releases in habChannel from depot. An empty habChannel returns
+// the releases in every channel.
+func (depo *depot) PackagesFromName(pkgName string, habChannel string) ([]PackageInfo, error) {
+	return depo.PackagesFromNameContext(context.Background(), pkgName, habChannel)
+}
 
-		offset = pkgsInfo.RangeEnd + 1
+// PackagesFromNameContext fetches all releases in habChannel from depot until ctx is done.
+func (depo *depot) PackagesFromNameContext(ctx context.Context, pkgName string, habChannel string) ([]PackageInfo, error) {
+	pages, err := depo.pages(ctx, pkgName)
+	if err != nil {
+		return nil, err
 	}
 
-	var versions []string
-	foundVersions := map[string]bool{}
+	// pages overlap when packages were added while they were fetched
+	var packages []PackageInfo
+	found := map[string]bool{}
+	for _, page := range pages {
+		for _, pkg := range page.PackageList {
+			if ident := pkg.Ident().String(); !found[ident] {
+				found[ident] = true
+				packages = append(packages, pkg)
+			}
+		}
+	}
+
+	var inHabChannel []PackageInfo
 	for _, pkg := range packages {
-		if foundVersions[pkg.Version] {
+		if !onTarget(pkg, depo.target) {
+			continue
+		}
+		if habChannel == "" {
+			inHabChannel = append(inHabChannel, pkg)
 			continue
 		}
 		for _, channel := range pkg.Channels {
 			if channel == habChannel {
-				versions = append(versions, pkg.Version)
-				foundVersions[pkg.Version] = true
+				inHabChannel = append(inHabChannel, pkg)
 				break
 			}
 		}
 	}
 
-	return versions, nil
+	return inHabChannel, nil
+}
+
+// PackageVersionsFromName fetches all versions from depot.
+func (depo *depot) PackageVersionsFromName(pkgName string, habChannel string) ([]string, error) {
+	return depo.PackageVersionsFromNameContext(context.Background(), pkgName, habChannel)
+}
+
+// PackageVersionsFromNameContext fetches all versions from depot until ctx is done.
+func (depo *depot) PackageVersionsFromNameContext(ctx context.Context, pkgName string, habChannel string) ([]string, error) {
+	packages, err := depo.PackagesFromNameContext(ctx, pkgName, habChannel)
+	if err != nil {
+		return nil, err
+	}
+	return uniqueVersions(packages), nil
+}
+
+// uniqueVersions returns the versions of packages without duplicates in their order.
+func uniqueVersions(packages []PackageInfo) []string {
+	var versions []string
+	foundVersions := map[string]bool{}
+	for _, pkg := range packages {
+		if foundVersions[pkg.Version] {
+			continue
+		}
+		versions = append(versions, pkg.Version)
+		foundVersions[pkg.Version] = true
+	}
+
+	return versions
 }
@@ -1,6 +1,7 @@
 package hab
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -406,7 +407,7 @@ func TestPackagesInfoFromName(t *testing.T) {
 			expected:      nil,
 			statusCode:    404,
 			httpError:     nil,
-			expectedError: errors.New("package not found"),
+			expectedError: ErrPackageNotFound,
 		},
 		{
 			packageName: "foo/test",
@@ -422,7 +423,7 @@ func TestPackagesInfoFromName(t *testing.T) {
 			expected:      nil,
 			statusCode:    500,
 			httpError:     nil,
-			expectedError: errors.New("unexpected status code: 500"),
+			expectedError: &DepotError{StatusCode: 500},
 		},
 		{
 			packageName: "foo/test",
@@ -438,7 +439,7 @@ func TestPackagesInfoFromName(t *testing.T) {
 			expected:      nil,
 			statusCode:    500,
 			httpError:     nil,
-			expectedError: errors.New("unexpected status code: 500"),
+			expectedError: &DepotError{StatusCode: 500},
 		},
 		{
 			packageName: "foo/test",
@@ -449,13 +450,13 @@ func TestPackagesInfoFromName(t *testing.T) {
 			expected:      nil,
 			statusCode:    200,
 			httpError:     nil,
-			expectedError: jsonError("corrupted json data"),
+			expectedError: &DepotError{StatusCode: 200, Err: jsonError("corrupted json data")},
 		},
 	}
 
 	for _, test := range tests {
 		http := makeFakeHTTPClient(t, test)
-		testDepot := &depot{testHabURL, http}
+		testDepot := &depot{baseURL: testHabURL, client: http}
 
 		results, err := testDepot.PackageVersionsFromName(test.packageName, test.channelName)
 
@@ -469,6 +470,11 @@ func TestPackagesInfoFromName(t *testing.T) {
 			} else if err.Error() != test.expectedError.Error() {
 				t.Errorf("Expected error message %v, actual %v", test.expectedError, err)
 			}
+			for _, target := range []error{ErrPackageNotFound, ErrDepotUnavailable} {
+				if errors.Is(err, target) != errors.Is(test.expectedError, target) {
+					t.Errorf("Expected errors.Is(%v, %v) to be %v", err, target, errors.Is(test.expectedError, target))
+				}
+			}
 		} else {
 			if !reflect.DeepEqual(results, test.expected) {
 				t.Errorf("Expected versions %v, actual %v", test.expected, results)
@@ -476,3 +482,31 @@ func TestPackagesInfoFromName(t *testing.T) {
 		}
 	}
 }
+
+// versionsDepot only tells the versions of packages, like the Depot implementations outside of
+// this package.
+type versionsDepot []string
+
+func (depo versionsDepot) PackageVersionsFromName(pkgName string, habChannel string) ([]string, error) {
+	return depo, nil
+}
+
+func TestPackagesFromNameContextVersions(t *testing.T) {
+	packages, err := PackagesFromNameContext(context.Background(), versionsDepot{"1.0.0", "1.1.0"}, "foo/test", "stable")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []PackageInfo{
+		{Origin: "foo", Name: "test", Version: "1.0.0", Channels: []string{"stable"}},
+		{Origin: "foo", Name: "test", Version: "1.1.0", Channels: []string{"stable"}},
+	}
+	if !reflect.DeepEqual(packages, expected) {
+		t.Errorf("Expected %v, actual %v", expected, packages)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := PackagesFromNameContext(ctx, versionsDepot{"1.0.0"}, "foo/test", "stable"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, actual %v", err)
+	}
+}
@@ -0,0 +1,3 @@
+// Package habtest provides an in-memory habitat depot server for testing code
+// built on the hab package.
+package habtest
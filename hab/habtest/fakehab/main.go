@@ -0,0 +1,298 @@
+// Fakehab is a scriptable stand-in for the hab command in end-to-end tests.
+//
+// It simulates `hab pkg path`, `hab pkg install`, `hab pkg exec`, `hab pkg env` and
+// `hab origin key import` against the packages under $FS_ROOT/hab/pkgs, and is
+// configured with these environment variables:
+//
+//	FAKEHAB_INDEX          JSON list of hab.PackageInfo which can be installed
+//	FAKEHAB_LOG            file which every invocation is appended to, one per line
+//	FAKEHAB_INSTALL_DELAY  duration to sleep before installing a package
+//	HAB_TARGET             target of the packages to install, any target if it is empty
+//
+// Installed packages get an executable bin/<name> script which prints the fully
+// qualified ident of the package followed by its arguments. Installing also saves the
+// package as a .hart file into the artifact cache and the public key of its origin into
+// the key cache, and `hab pkg install` of such a .hart file installs the package in it.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/screwdriver-cd/sd-step/hab"
+)
+
+func main() {
+	logInvocation(os.Args[1:])
+	os.Exit(run(os.Args[1:]))
+}
+
+// logInvocation appends args to FAKEHAB_LOG.
+func logInvocation(args []string) {
+	logPath := os.Getenv("FAKEHAB_LOG")
+	if logPath == "" {
+		return
+	}
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fakehab: %v\n", err)
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, strings.Join(args, " "))
+}
+
+// run runs the hab subcommand and returns the exit code.
+func run(args []string) int {
+	if len(args) < 3 {
+		return usage()
+	}
+
+	switch strings.Join(args[:2], " ") {
+	case "pkg path":
+		return pkgPath(args[2])
+	case "pkg install":
+		return pkgInstall(args[2], channelFlag(args[3:]))
+	case "pkg exec":
+		if len(args) < 4 {
+			return usage()
+		}
+		return pkgExec(args[2], args[3:])
+	case "pkg env":
+		return pkgEnv(args[2])
+	case "origin key":
+		if args[2] != "import" {
+			return usage()
+		}
+		return originKeyImport()
+	}
+	return usage()
+}
+
+func usage() int {
+	fmt.Fprintln(os.Stderr, "fakehab: unsupported command")
+	return 2
+}
+
+// channelFlag returns the value of -c in args, stable by default.
+func channelFlag(args []string) string {
+	for i, arg := range args {
+		if (arg == "-c" || arg == "--channel") && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return "stable"
+}
+
+// fsRoot returns the filesystem root like hab does.
+func fsRoot() string {
+	if root := os.Getenv("FS_ROOT"); root != "" {
+		return root
+	}
+	return "/"
+}
+
+// installedPath returns the directory of the latest installed package matching ident.
+func installedPath(ident string) (string, bool) {
+	pattern := filepath.Join(fsRoot(), "hab", "pkgs", filepath.FromSlash(ident))
+	for i := strings.Count(ident, "/"); i < 3; i++ {
+		pattern = filepath.Join(pattern, "*")
+	}
+	dirs, _ := filepath.Glob(pattern)
+	if len(dirs) == 0 {
+		return "", false
+	}
+	sort.Strings(dirs)
+	return dirs[len(dirs)-1], true
+}
+
+func pkgPath(ident string) int {
+	dir, ok := installedPath(ident)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "✗✗✗\n✗✗✗ Cannot find package: %s\n✗✗✗\n", ident)
+		return 1
+	}
+	fmt.Println(dir)
+	return 0
+}
+
+// matches checks if pkg is the package identified by a partial or fully qualified ident.
+func matches(pkg hab.PackageInfo, ident string) bool {
+	fields := []string{pkg.Origin, pkg.Name, pkg.Version, pkg.Release}
+	parts := strings.Split(ident, "/")
+	if len(parts) < 2 || len(parts) > len(fields) {
+		return false
+	}
+	for i, part := range parts {
+		if fields[i] != part {
+			return false
+		}
+	}
+	return true
+}
+
+func pkgInstall(ident, channel string) int {
+	if delay, err := time.ParseDuration(os.Getenv("FAKEHAB_INSTALL_DELAY")); err == nil {
+		time.Sleep(delay)
+	}
+	if strings.HasSuffix(ident, ".hart") {
+		return installArtifact(ident)
+	}
+
+	var packages []hab.PackageInfo
+	if data, err := ioutil.ReadFile(os.Getenv("FAKEHAB_INDEX")); err == nil {
+		if err := json.Unmarshal(data, &packages); err != nil {
+			fmt.Fprintf(os.Stderr, "fakehab: invalid index: %v\n", err)
+			return 1
+		}
+	}
+
+	var found *hab.PackageInfo
+	target := os.Getenv("HAB_TARGET")
+	for i, pkg := range packages {
+		if !matches(pkg, ident) || target != "" && pkg.Target != "" && pkg.Target != target {
+			continue
+		}
+		for _, c := range pkg.Channels {
+			// the index lists packages from older to newer, so the last match wins
+			if c == channel {
+				found = &packages[i]
+			}
+		}
+	}
+	if found == nil {
+		fmt.Fprintf(os.Stderr, "✗✗✗\n✗✗✗ Cannot find a release of package: %s in %s\n✗✗✗\n", ident, channel)
+		return 1
+	}
+	return install(*found)
+}
+
+// installArtifact installs the package in the .hart file which install saved.
+func installArtifact(path string) int {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fakehab: %v\n", err)
+		return 1
+	}
+	var pkg hab.PackageInfo
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		fmt.Fprintf(os.Stderr, "fakehab: invalid artifact: %v\n", err)
+		return 1
+	}
+	return install(pkg)
+}
+
+// install installs pkg under $FS_ROOT/hab/pkgs and caches its artifact and origin key.
+func install(pkg hab.PackageInfo) int {
+	fullIdent := strings.Join([]string{pkg.Origin, pkg.Name, pkg.Version, pkg.Release}, "/")
+	dir := filepath.Join(fsRoot(), "hab", "pkgs", filepath.FromSlash(fullIdent))
+	if err := os.MkdirAll(filepath.Join(dir, "bin"), 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "fakehab: %v\n", err)
+		return 1
+	}
+	script := fmt.Sprintf("#!/bin/sh\necho %s \"$@\"\n", fullIdent)
+	if err := ioutil.WriteFile(filepath.Join(dir, "bin", pkg.Name), []byte(script), 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "fakehab: %v\n", err)
+		return 1
+	}
+	ioutil.WriteFile(filepath.Join(dir, "IDENT"), []byte(fullIdent+"\n"), 0644)
+	if pkg.Target != "" {
+		ioutil.WriteFile(filepath.Join(dir, "TARGET"), []byte(pkg.Target+"\n"), 0644)
+	}
+
+	target := pkg.Target
+	if target == "" {
+		target = "x86_64-linux"
+	}
+	artifacts := filepath.Join(fsRoot(), "hab", "cache", "artifacts")
+	artifact := strings.Join([]string{pkg.Origin, pkg.Name, pkg.Version, pkg.Release, target}, "-") + ".hart"
+	data, _ := json.Marshal(pkg)
+	if err := os.MkdirAll(artifacts, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "fakehab: %v\n", err)
+		return 1
+	}
+	if err := ioutil.WriteFile(filepath.Join(artifacts, artifact), data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "fakehab: %v\n", err)
+		return 1
+	}
+	keyName := pkg.Origin + "-20170101000000"
+	if err := saveKey(keyName, "SIG-PUB-1\n"+keyName+"\n\nfakekey\n"); err != nil {
+		fmt.Fprintf(os.Stderr, "fakehab: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("★ Install of %s complete with 1 new packages installed.\n", fullIdent)
+	return 0
+}
+
+func pkgExec(ident string, command []string) int {
+	dir, ok := installedPath(ident)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "✗✗✗\n✗✗✗ Cannot find package: %s\n✗✗✗\n", ident)
+		return 1
+	}
+
+	os.Setenv("PATH", filepath.Join(dir, "bin")+string(os.PathListSeparator)+os.Getenv("PATH"))
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode()
+		}
+		fmt.Fprintf(os.Stderr, "fakehab: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func pkgEnv(ident string) int {
+	dir, ok := installedPath(ident)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "✗✗✗\n✗✗✗ Cannot find package: %s\n✗✗✗\n", ident)
+		return 1
+	}
+	fmt.Printf("export PATH=\"%s:$PATH\"\n", filepath.Join(dir, "bin"))
+	return 0
+}
+
+func originKeyImport() int {
+	key, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fakehab: %v\n", err)
+		return 1
+	}
+
+	lines := strings.SplitN(string(key), "\n", 3)
+	if len(lines) < 2 || lines[1] == "" {
+		fmt.Fprintln(os.Stderr, "fakehab: invalid key")
+		return 1
+	}
+
+	if err := saveKey(lines[1], string(key)); err != nil {
+		fmt.Fprintf(os.Stderr, "fakehab: %v\n", err)
+		return 1
+	}
+	fmt.Printf("» Imported public origin key %s.\n", lines[1])
+	return 0
+}
+
+// saveKey saves the public key of the name into the key cache like hab does.
+func saveKey(name, key string) error {
+	keyPath := os.Getenv("HAB_CACHE_KEY_PATH")
+	if keyPath == "" {
+		keyPath = filepath.Join(fsRoot(), "hab", "cache", "keys")
+	}
+	if err := os.MkdirAll(keyPath, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(keyPath, name+".pub"), []byte(key), 0644)
+}
@@ -0,0 +1,389 @@
+package habtest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/screwdriver-cd/sd-step/hab"
+)
+
+// DefaultPageSize is the number of packages in a page like the depot API.
+const DefaultPageSize = 50
+
+// Fault is a failure injected into a response of Server.
+type Fault struct {
+	// Latency delays the response.
+	Latency time.Duration
+	// StatusCode replaces the status code of the response if it is not zero.
+	StatusCode int
+	// Malformed replaces the response body with invalid JSON.
+	Malformed bool
+}
+
+// Request is a request recorded by Server.
+type Request struct {
+	Method string
+	URL    *url.URL
+	Header http.Header
+}
+
+// Server is a fake depot serving seeded packages over HTTP.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	pageSize int
+	packages []hab.PackageInfo
+	details  map[string]hab.PackageDetail
+	latency  time.Duration
+	faults   []Fault
+	requests []Request
+}
+
+// NewServer starts and returns a new Server seeded with packages.
+// The caller should call Close when finished, to shut it down.
+func NewServer(packages ...hab.PackageInfo) *Server {
+	s := &Server{pageSize: DefaultPageSize}
+	s.Add(packages...)
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Package returns a PackageInfo for a fully qualified ident like core/node/8.9.0/20171101000000
+// which belongs to channels.
+func Package(ident string, channels ...string) hab.PackageInfo {
+	parts := strings.SplitN(ident, "/", 4)
+	for len(parts) < 4 {
+		parts = append(parts, "")
+	}
+	return hab.PackageInfo{
+		Origin:   parts[0],
+		Name:     parts[1],
+		Version:  parts[2],
+		Release:  parts[3],
+		Channels: channels,
+	}
+}
+
+// DepotURL returns the base URL of the depot API to pass to hab.New.
+func (s *Server) DepotURL() string {
+	return s.URL + "/v1/depot"
+}
+
+// Add seeds packages into the depot.
+func (s *Server) Add(packages ...hab.PackageInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.packages = append(s.packages, packages...)
+}
+
+// SetDetail seeds the detail of the package it identifies, which is returned instead of the
+// default detail generated from the package. The package is added if it is not seeded yet.
+func (s *Server) SetDetail(detail hab.PackageDetail) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ident := detail.Ident.String()
+	found := false
+	for _, pkg := range s.packages {
+		if pkg.Ident().String() == ident {
+			found = true
+			break
+		}
+	}
+	if !found {
+		s.packages = append(s.packages, hab.PackageInfo{
+			Origin:   detail.Ident.Origin,
+			Name:     detail.Ident.Name,
+			Version:  detail.Ident.Version,
+			Release:  detail.Ident.Release,
+			Channels: detail.Channels,
+		})
+	}
+	if s.details == nil {
+		s.details = map[string]hab.PackageDetail{}
+	}
+	s.details[ident] = detail
+}
+
+// SetPageSize sets the number of packages in a page of package listings.
+func (s *Server) SetPageSize(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pageSize = n
+}
+
+// SetLatency delays every response by d.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// Inject makes the following responses fail with faults, one fault per request in order.
+func (s *Server) Inject(faults ...Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, faults...)
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Reset forgets the recorded requests and pending faults.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = nil
+	s.faults = nil
+}
+
+// handle serves a request with a pending fault applied.
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	u := *r.URL
+	s.requests = append(s.requests, Request{r.Method, &u, r.Header.Clone()})
+	var fault Fault
+	if len(s.faults) > 0 {
+		fault, s.faults = s.faults[0], s.faults[1:]
+	}
+	latency := s.latency + fault.Latency
+	s.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	status, body := s.route(r)
+	if fault.StatusCode != 0 {
+		status, body = fault.StatusCode, map[string]string{"error": http.StatusText(fault.StatusCode)}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if fault.Malformed {
+		fmt.Fprint(w, `{"range_start": 0, "data": [`)
+		return
+	}
+	json.NewEncoder(w).Encode(body)
+}
+
+// route returns the status code and the body for the request.
+func (s *Server) route(r *http.Request) (int, interface{}) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1/depot/")
+	if r.Method != http.MethodGet || path == r.URL.Path {
+		return http.StatusNotFound, nil
+	}
+
+	// the target query limits the packages to the ones built for it
+	target := r.URL.Query().Get("target")
+
+	// a search term may have slashes like core/no
+	if term := strings.TrimPrefix(path, "pkgs/search/"); term != path {
+		return s.search(term, r.URL.Query())
+	}
+
+	parts := strings.Split(path, "/")
+	switch {
+	case len(parts) == 3 && parts[0] == "pkgs":
+		return s.packageList(parts[1], parts[2], r.URL.Query())
+	case len(parts) == 4 && parts[0] == "pkgs" && parts[3] == "latest":
+		return s.latest(hab.PackageIdent{Origin: parts[1], Name: parts[2]}, "", target)
+	case len(parts) == 5 && parts[0] == "pkgs" && parts[4] == "latest":
+		return s.latest(hab.PackageIdent{Origin: parts[1], Name: parts[2], Version: parts[3]}, "", target)
+	case len(parts) == 5 && parts[0] == "pkgs":
+		return s.latest(hab.PackageIdent{Origin: parts[1], Name: parts[2], Version: parts[3], Release: parts[4]}, "", target)
+	case len(parts) == 6 && parts[0] == "channels" && parts[3] == "pkgs" && parts[5] == "latest":
+		return s.latest(hab.PackageIdent{Origin: parts[1], Name: parts[4]}, parts[2], target)
+	case len(parts) == 7 && parts[0] == "channels" && parts[3] == "pkgs" && parts[6] == "latest":
+		return s.latest(hab.PackageIdent{Origin: parts[1], Name: parts[4], Version: parts[5]}, parts[2], target)
+	case len(parts) == 2 && parts[0] == "channels":
+		return s.originChannels(parts[1])
+	}
+	return http.StatusNotFound, nil
+}
+
+// packageList returns a page of the packages of origin/name starting at the range query.
+func (s *Server) packageList(origin, name string, query url.Values) (int, interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var matched []hab.PackageInfo
+	found := false
+	for _, pkg := range s.packages {
+		if pkg.Origin == origin && pkg.Name == name {
+			found = true
+			if onTarget(pkg, query.Get("target")) {
+				matched = append(matched, pkg)
+			}
+		}
+	}
+	if !found {
+		return http.StatusNotFound, nil
+	}
+
+	return page(matched, query, s.pageSize)
+}
+
+// search returns a page of the packages whose origin/name contains term.
+func (s *Server) search(term string, query url.Values) (int, interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var matched []hab.PackageInfo
+	for _, pkg := range s.packages {
+		if strings.Contains(pkg.Origin+"/"+pkg.Name, term) && onTarget(pkg, query.Get("target")) {
+			matched = append(matched, pkg)
+		}
+	}
+	return page(matched, query, s.pageSize)
+}
+
+// latest returns the detail of the latest package matching ident on target in habChannel, or in
+// any channel if habChannel is empty.
+func (s *Server) latest(ident hab.PackageIdent, habChannel string, target string) (int, interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var latest *hab.PackageInfo
+	for i, pkg := range s.packages {
+		if pkg.Origin != ident.Origin || pkg.Name != ident.Name ||
+			ident.Version != "" && pkg.Version != ident.Version ||
+			ident.Release != "" && pkg.Release != ident.Release ||
+			habChannel != "" && !inChannel(pkg, habChannel) ||
+			!onTarget(pkg, target) {
+			continue
+		}
+		if latest == nil || pkg.Ident().Compare(latest.Ident()) > 0 {
+			latest = &s.packages[i]
+		}
+	}
+	if latest == nil {
+		return http.StatusNotFound, nil
+	}
+
+	if detail, ok := s.details[latest.Ident().String()]; ok {
+		return http.StatusOK, detail
+	}
+	return http.StatusOK, Detail(*latest)
+}
+
+// Detail returns the default detail of pkg which Server serves, whose target is the one of pkg or
+// x86_64-linux and checksum is the SHA-256 of the ident.
+func Detail(pkg hab.PackageInfo) hab.PackageDetail {
+	sum := sha256.Sum256([]byte(pkg.Ident().String()))
+	target := pkg.Target
+	if target == "" {
+		target = "x86_64-linux"
+	}
+	return hab.PackageDetail{
+		Ident:    pkg.Ident(),
+		Checksum: hex.EncodeToString(sum[:]),
+		Manifest: "# " + pkg.Ident().String() + "\n",
+		Target:   target,
+		Deps:     []hab.PackageIdent{},
+		TDeps:    []hab.PackageIdent{},
+		Exposes:  []int{},
+		Channels: pkg.Channels,
+	}
+}
+
+// originChannels returns the channels which the packages of origin belong to.
+func (s *Server) originChannels(origin string) (int, interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := map[string]bool{}
+	originFound := false
+	for _, pkg := range s.packages {
+		if pkg.Origin != origin {
+			continue
+		}
+		originFound = true
+		for _, channel := range pkg.Channels {
+			found[channel] = true
+		}
+	}
+	if !originFound {
+		return http.StatusNotFound, nil
+	}
+
+	var names []string
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	channels := []map[string]string{}
+	for _, name := range names {
+		channels = append(channels, map[string]string{"name": name})
+	}
+	return http.StatusOK, channels
+}
+
+// onTarget checks if pkg is built for target. A package without a target is on every target.
+func onTarget(pkg hab.PackageInfo, target string) bool {
+	return target == "" || pkg.Target == "" || pkg.Target == target
+}
+
+// inChannel checks if pkg belongs to habChannel.
+func inChannel(pkg hab.PackageInfo, habChannel string) bool {
+	for _, channel := range pkg.Channels {
+		if channel == habChannel {
+			return true
+		}
+	}
+	return false
+}
+
+// page returns the page of packages starting at the range query like the depot API.
+// A range beyond the last package results in an empty page with zero total count.
+func page(packages []hab.PackageInfo, query url.Values, pageSize int) (int, interface{}) {
+	start := 0
+	if r := query.Get("range"); r != "" {
+		var err error
+		if start, err = strconv.Atoi(r); err != nil || start < 0 {
+			return http.StatusBadRequest, nil
+		}
+	}
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+
+	if start >= len(packages) {
+		return http.StatusOK, hab.PackagesInfo{RangeStart: start, PackageList: []hab.PackageInfo{}}
+	}
+
+	end := start + pageSize
+	if end > len(packages) {
+		end = len(packages)
+	}
+
+	status := http.StatusOK
+	if end-start < len(packages) {
+		status = http.StatusPartialContent
+	}
+
+	return status, hab.PackagesInfo{
+		RangeStart:  start,
+		RangeEnd:    end - 1,
+		TotalCount:  len(packages),
+		PackageList: packages[start:end],
+	}
+}
@@ -0,0 +1,369 @@
+package habtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/screwdriver-cd/sd-step/hab"
+)
+
+func TestServerPagination(t *testing.T) {
+	server := NewServer(
+		Package("foo/test/0.0.1/20170524100001", "stable"),
+		Package("foo/test/0.0.2/20170524100002", "unstable"),
+		Package("foo/test/0.1.0/20170524100003", "stable"),
+		Package("foo/test/0.1.0/20170524100004", "stable", "unstable"),
+		Package("foo/test/1.0.0/20170524100005", "stable"),
+		Package("foo/other/2.0.0/20170524100006", "stable"),
+	)
+	defer server.Close()
+	server.SetPageSize(2)
+
+	versions, err := hab.New(server.DepotURL()).PackageVersionsFromName("foo/test", "stable")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{"0.0.1", "0.1.0", "1.0.0"}
+	if !reflect.DeepEqual(versions, expected) {
+		t.Errorf("Expected versions %v, actual %v", expected, versions)
+	}
+
+	var ranges []string
+	for _, request := range server.Requests() {
+		if request.URL.Path != "/v1/depot/pkgs/foo/test" {
+			t.Errorf("Unexpected request path %s", request.URL.Path)
+		}
+		ranges = append(ranges, request.URL.Query().Get("range"))
+	}
+	// the pages after the first one are fetched concurrently
+	sort.Strings(ranges[1:])
+	expectedRanges := []string{"0", "2", "4", "5"}
+	if !reflect.DeepEqual(ranges, expectedRanges) {
+		t.Errorf("Expected ranges %v, actual %v", expectedRanges, ranges)
+	}
+}
+
+func TestServerConcurrentPagination(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.SetPageSize(3)
+	var expected []string
+	for i := 0; i < 40; i++ {
+		version := fmt.Sprintf("1.0.%d", i)
+		server.Add(Package("foo/test/"+version+"/20170524100001", "stable"))
+		expected = append(expected, version)
+	}
+	depot := hab.New(server.DepotURL())
+
+	versions, err := depot.PackageVersionsFromName("foo/test", "stable")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(versions, expected) {
+		t.Errorf("Expected versions %v in order, actual %v", expected, versions)
+	}
+
+	// the first error cancels the pages in flight
+	server.Reset()
+	faults := []Fault{{}, {StatusCode: http.StatusServiceUnavailable}}
+	for i := 0; i < 20; i++ {
+		faults = append(faults, Fault{Latency: 5 * time.Second})
+	}
+	server.Inject(faults...)
+	start := time.Now()
+	if _, err := depot.PackageVersionsFromName("foo/test", "stable"); !errors.Is(err, hab.ErrDepotUnavailable) {
+		t.Errorf("Expected ErrDepotUnavailable, actual %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the pages in flight to be cancelled, actual %v elapsed", elapsed)
+	}
+}
+
+func TestServerPackagesFromName(t *testing.T) {
+	server := NewServer(
+		Package("foo/test/0.1.0/20170524100003", "stable"),
+		Package("foo/test/0.1.0/20170524100004", "unstable"),
+		Package("foo/test/1.0.0/20170524100005", "stable"),
+	)
+	defer server.Close()
+
+	tests := map[string][]string{
+		"stable":   {"foo/test/0.1.0/20170524100003", "foo/test/1.0.0/20170524100005"},
+		"unstable": {"foo/test/0.1.0/20170524100004"},
+		"":         {"foo/test/0.1.0/20170524100003", "foo/test/0.1.0/20170524100004", "foo/test/1.0.0/20170524100005"},
+	}
+
+	for channel, expected := range tests {
+		packages, err := hab.New(server.DepotURL()).PackagesFromName("foo/test", channel)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var releases []string
+		for _, pkg := range packages {
+			releases = append(releases, pkg.Ident().String())
+		}
+		if !reflect.DeepEqual(releases, expected) {
+			t.Errorf("Expected releases %v in channel %q, actual %v", expected, channel, releases)
+		}
+	}
+}
+
+func TestServerPackageDetail(t *testing.T) {
+	server := NewServer(
+		Package("foo/test/0.1.0/20170524100003", "stable"),
+		Package("foo/test/0.1.0/20170524100004", "unstable"),
+		Package("foo/test/1.0.0/20170524100005", "unstable"),
+		Package("foo/other/1.0.0/20170524100006", "beta"),
+	)
+	defer server.Close()
+	seeded := hab.PackageDetail{
+		Ident:    hab.PackageIdent{Origin: "foo", Name: "test", Version: "0.1.0", Release: "20170524100003"},
+		Checksum: "0123456789abcdef",
+		Target:   "aarch64-linux",
+		Deps:     []hab.PackageIdent{{Origin: "core", Name: "glibc", Version: "2.22", Release: "20170513215607"}},
+		Exposes:  []int{8080},
+		Config:   "port = 8080\n",
+		Channels: []string{"stable"},
+	}
+	server.SetDetail(seeded)
+
+	depot := hab.New(server.DepotURL())
+	ctx := context.Background()
+
+	detail, err := depot.PackageDetail(ctx, "foo/test/0.1.0/20170524100003")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(detail, seeded) {
+		t.Errorf("Expected %+v, actual %+v", seeded, detail)
+	}
+
+	tests := []struct {
+		pkgName  string
+		channel  string
+		expected string
+	}{
+		{"foo/test", "", "foo/test/1.0.0/20170524100005"},
+		{"foo/test/0.1.0", "", "foo/test/0.1.0/20170524100004"},
+		{"foo/test", "stable", "foo/test/0.1.0/20170524100003"},
+		{"foo/test", "unstable", "foo/test/1.0.0/20170524100005"},
+		{"foo/test/0.1.0", "unstable", "foo/test/0.1.0/20170524100004"},
+	}
+	for _, test := range tests {
+		if test.channel == "" {
+			detail, err = depot.PackageDetail(ctx, test.pkgName)
+		} else {
+			detail, err = depot.LatestInChannel(ctx, test.pkgName, test.channel)
+		}
+		if err != nil {
+			t.Errorf("%s in %q: unexpected error: %v", test.pkgName, test.channel, err)
+			continue
+		}
+		if ident := detail.Ident.String(); ident != test.expected {
+			t.Errorf("%s in %q: expected %v, actual %v", test.pkgName, test.channel, test.expected, ident)
+		}
+	}
+	if expected := Detail(Package("foo/test/0.1.0/20170524100004", "unstable")); !reflect.DeepEqual(detail, expected) {
+		t.Errorf("Expected the default detail %+v, actual %+v", expected, detail)
+	}
+
+	if _, err := depot.LatestInChannel(ctx, "foo/test", "beta"); !errors.Is(err, hab.ErrPackageNotFound) {
+		t.Errorf("Expected ErrPackageNotFound, actual %v", err)
+	}
+
+	channels, err := depot.OriginChannels(ctx, "foo")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := []string{"beta", "stable", "unstable"}; !reflect.DeepEqual(channels, expected) {
+		t.Errorf("Expected channels %v, actual %v", expected, channels)
+	}
+	if _, err := depot.OriginChannels(ctx, "bar"); !errors.Is(err, hab.ErrOriginNotFound) {
+		t.Errorf("Expected ErrOriginNotFound, actual %v", err)
+	}
+
+	server.Inject(Fault{StatusCode: http.StatusServiceUnavailable})
+	if _, err := depot.PackageDetail(ctx, "foo/test"); !errors.Is(err, hab.ErrDepotUnavailable) {
+		t.Errorf("Expected ErrDepotUnavailable, actual %v", err)
+	}
+}
+
+func TestServerSearch(t *testing.T) {
+	server := NewServer(
+		Package("core/node/8.9.0/20171101000000", "stable"),
+		Package("core/node/8.10.0/20180301000000", "stable"),
+		Package("core/nodejs-tools/1.0.0/20180301000000", "unstable"),
+		Package("foo/node-app/0.1.0/20180301000000", "stable"),
+		Package("core/yarn/1.3.2/20171116203524", "stable"),
+	)
+	defer server.Close()
+	server.SetPageSize(2)
+
+	tests := map[string][]string{
+		"node":      {"core/node", "core/nodejs-tools", "foo/node-app"},
+		"core/node": {"core/node", "core/nodejs-tools"},
+		"python":    nil,
+	}
+
+	for term, expected := range tests {
+		idents, err := hab.New(server.DepotURL()).Search(context.Background(), term)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var names []string
+		for _, ident := range idents {
+			names = append(names, ident.String())
+		}
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("Expected %v for %q, actual %v", expected, term, names)
+		}
+	}
+}
+
+func TestServerTarget(t *testing.T) {
+	onTarget := func(ident, target string) hab.PackageInfo {
+		pkg := Package(ident, "stable")
+		pkg.Target = target
+		return pkg
+	}
+	server := NewServer(
+		onTarget("foo/test/1.0.0/20170524100001", "x86_64-linux"),
+		onTarget("foo/test/1.0.0/20170524100002", "aarch64-linux"),
+		onTarget("foo/test/1.1.0/20170524100003", "x86_64-linux"),
+		Package("foo/test/0.9.0/20170524100000", "stable"),
+		onTarget("foo/arm-only/1.0.0/20170524100004", "aarch64-linux"),
+	)
+	defer server.Close()
+	ctx := context.Background()
+
+	tests := map[string][]string{
+		"x86_64-linux":  {"1.0.0", "1.1.0", "0.9.0"},
+		"aarch64-linux": {"1.0.0", "0.9.0"},
+		"":              {"1.0.0", "1.1.0", "0.9.0"},
+	}
+	for target, expected := range tests {
+		depot := hab.New(server.DepotURL(), hab.WithTarget(target))
+		versions, err := depot.PackageVersionsFromName("foo/test", "stable")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(versions, expected) {
+			t.Errorf("Expected versions %v on %q, actual %v", expected, target, versions)
+		}
+	}
+
+	server.Reset()
+	depot := hab.New(server.DepotURL(), hab.WithTarget("aarch64-linux"))
+	detail, err := depot.LatestInChannel(ctx, "foo/test", "stable")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if detail.Ident.String() != "foo/test/1.0.0/20170524100002" || detail.Target != "aarch64-linux" {
+		t.Errorf("Expected foo/test/1.0.0/20170524100002 on aarch64-linux, actual %+v", detail)
+	}
+	for _, request := range server.Requests() {
+		if target := request.URL.Query().Get("target"); target != "aarch64-linux" {
+			t.Errorf("Expected the target query in %v", request.URL)
+		}
+	}
+
+	idents, err := hab.New(server.DepotURL(), hab.WithTarget("x86_64-linux")).Search(ctx, "foo/")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(idents) != 1 || idents[0].String() != "foo/test" {
+		t.Errorf("Expected only foo/test on x86_64-linux, actual %v", idents)
+	}
+}
+
+func TestServerFaults(t *testing.T) {
+	server := NewServer(Package("foo/test/0.0.1/20170524100001", "stable"))
+	defer server.Close()
+	depot := hab.New(server.DepotURL())
+
+	tests := []struct {
+		faults      []Fault
+		expectError bool
+	}{
+		{nil, false},
+		{[]Fault{{StatusCode: http.StatusServiceUnavailable}}, true},
+		{[]Fault{{Malformed: true}}, true},
+		{[]Fault{{Latency: 10 * time.Millisecond}}, false},
+	}
+
+	for _, test := range tests {
+		server.Reset()
+		server.Inject(test.faults...)
+
+		_, err := depot.PackageVersionsFromName("foo/test", "stable")
+		if test.expectError && err == nil {
+			t.Errorf("Expected error with faults %v, actual nil", test.faults)
+		}
+		if !test.expectError && err != nil {
+			t.Errorf("Unexpected error with faults %v: %v", test.faults, err)
+		}
+	}
+
+	if _, err := depot.PackageVersionsFromName("foo/missing", "stable"); err == nil {
+		t.Errorf("Expected error for a missing package, actual nil")
+	}
+}
+
+// countingTransport counts the requests sent through it.
+type countingTransport struct {
+	mu    sync.Mutex
+	count int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.count++
+	t.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestServerDepotOptions(t *testing.T) {
+	server := NewServer(Package("foo/test/0.0.1/20170524100001", "stable"))
+	defer server.Close()
+
+	transport := &countingTransport{}
+	depot := hab.New(server.DepotURL(), hab.WithTransport(transport), hab.WithUserAgent("sd-step/1.2.3"))
+	if _, err := depot.PackageVersionsFromName("foo/test", "stable"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if requests := len(server.Requests()); transport.count != requests {
+		t.Errorf("Expected %d requests through the transport, actual %d", requests, transport.count)
+	}
+	if userAgent := server.Requests()[0].Header.Get("User-Agent"); userAgent != "sd-step/1.2.3" {
+		t.Errorf("Expected User-Agent sd-step/1.2.3, actual %q", userAgent)
+	}
+
+	server.SetLatency(5 * time.Second)
+	start := time.Now()
+	_, err := hab.New(server.DepotURL(), hab.WithTimeout(50*time.Millisecond)).PackageVersionsFromName("foo/test", "stable")
+	if !errors.Is(err, hab.ErrDepotUnavailable) {
+		t.Errorf("Expected ErrDepotUnavailable after the timeout, actual %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = hab.PackageVersionsFromNameContext(ctx, depot, "foo/test", "stable")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, actual %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the requests to be stopped, actual %v elapsed", elapsed)
+	}
+
+	// depots which do not take a context are not called once it is done
+	if _, err := hab.PackagesFromNameContext(ctx, hab.NewDirDepot(t.TempDir()), "foo/test", "stable"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from a directory depot, actual %v", err)
+	}
+}
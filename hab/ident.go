@@ -0,0 +1,105 @@
+package hab
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	originValidator  = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	nameValidator    = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	versionValidator = regexp.MustCompile(`^[A-Za-z0-9_.+-]+$`)
+	releaseValidator = regexp.MustCompile(`^\d{14}$`)
+)
+
+// maxOriginLength is the longest origin name which Habitat accepts.
+const maxOriginLength = 255
+
+// PackageIdent identifies a package as origin/name[/version[/release]].
+type PackageIdent struct {
+	Origin  string `json:"origin"`
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	Release string `json:"release,omitempty"`
+}
+
+// Ident returns the identifier of the package.
+func (pkg PackageInfo) Ident() PackageIdent {
+	return PackageIdent{pkg.Origin, pkg.Name, pkg.Version, pkg.Release}
+}
+
+// ParseIdent parses an identifier like core/node, core/node/8.9.0 or
+// core/node/8.9.0/20171101000000 and validates it.
+func ParseIdent(s string) (PackageIdent, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 4 {
+		return PackageIdent{}, fmt.Errorf("%v is invalid package identifier, it must be origin/name[/version[/release]]", s)
+	}
+
+	var ident PackageIdent
+	ident.Origin, ident.Name = parts[0], parts[1]
+	if len(parts) > 2 {
+		ident.Version = parts[2]
+	}
+	if len(parts) > 3 {
+		ident.Release = parts[3]
+	}
+
+	if err := ident.Validate(); err != nil {
+		return PackageIdent{}, err
+	}
+	return ident, nil
+}
+
+// Validate checks the parts of the identifier against the naming rules of Habitat.
+func (ident PackageIdent) Validate() error {
+	if len(ident.Origin) > maxOriginLength || !originValidator.MatchString(ident.Origin) {
+		return fmt.Errorf("%v is invalid origin, it must consist of lowercase letters, digits, - and _", ident.Origin)
+	}
+	if !nameValidator.MatchString(ident.Name) {
+		return fmt.Errorf("%v is invalid package name, it must consist of letters, digits, - and _", ident.Name)
+	}
+	if ident.Version == "" && ident.Release != "" {
+		return fmt.Errorf("release %v is specified without version", ident.Release)
+	}
+	if ident.Version != "" && !versionValidator.MatchString(ident.Version) {
+		return fmt.Errorf("%v is invalid version", ident.Version)
+	}
+	if ident.Release != "" && !releaseValidator.MatchString(ident.Release) {
+		return fmt.Errorf("%v is invalid release, it must be a 14 digit timestamp", ident.Release)
+	}
+	return nil
+}
+
+// FullyQualified checks if the identifier has both version and release.
+func (ident PackageIdent) FullyQualified() bool {
+	return ident.Version != "" && ident.Release != ""
+}
+
+// Package returns the origin/name part of the identifier.
+func (ident PackageIdent) Package() string {
+	return ident.Origin + "/" + ident.Name
+}
+
+// String returns the identifier in the form which hab accepts.
+func (ident PackageIdent) String() string {
+	s := ident.Package()
+	if ident.Version != "" {
+		s += "/" + ident.Version
+		if ident.Release != "" {
+			s += "/" + ident.Release
+		}
+	}
+	return s
+}
+
+// Compare compares the version and then the release of identifiers, and returns -1, 0 or 1
+// if ident is older than, the same as or newer than other.
+func (ident PackageIdent) Compare(other PackageIdent) int {
+	if c := CompareVersions(ident.Version, other.Version); c != 0 {
+		return c
+	}
+	// releases are timestamps of the same length, so they sort as strings
+	return strings.Compare(ident.Release, other.Release)
+}
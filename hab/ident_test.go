@@ -0,0 +1,72 @@
+package hab
+
+import (
+	"testing"
+)
+
+func TestParseIdent(t *testing.T) {
+	tests := []struct {
+		ident          string
+		expected       PackageIdent
+		fullyQualified bool
+		expectError    bool
+	}{
+		{"core/node", PackageIdent{"core", "node", "", ""}, false, false},
+		{"core/node/8.9.0", PackageIdent{"core", "node", "8.9.0", ""}, false, false},
+		{"core/node/8.9.0/20171101000000", PackageIdent{"core", "node", "8.9.0", "20171101000000"}, true, false},
+		{"my-origin/Node_JS/1.0.0-rc1", PackageIdent{"my-origin", "Node_JS", "1.0.0-rc1", ""}, false, false},
+		{"core", PackageIdent{}, false, true},
+		{"core/node/8.9.0/20171101000000/x86_64-linux", PackageIdent{}, false, true},
+		{"Core/node", PackageIdent{}, false, true},
+		{"-core/node", PackageIdent{}, false, true},
+		{"core/node.js", PackageIdent{}, false, true},
+		{"core//8.9.0", PackageIdent{}, false, true},
+		{"core/node/^8", PackageIdent{}, false, true},
+		{"core/node/8.9.0/latest", PackageIdent{}, false, true},
+	}
+
+	for _, test := range tests {
+		ident, err := ParseIdent(test.ident)
+		if test.expectError {
+			if err == nil {
+				t.Errorf("Expected error for %q, actual %v", test.ident, ident)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", test.ident, err)
+			continue
+		}
+		if ident != test.expected {
+			t.Errorf("Expected %#v, actual %#v", test.expected, ident)
+		}
+		if ident.String() != test.ident {
+			t.Errorf("Expected %q, actual %q", test.ident, ident.String())
+		}
+		if ident.FullyQualified() != test.fullyQualified {
+			t.Errorf("Expected FullyQualified() of %q to be %v", test.ident, test.fullyQualified)
+		}
+	}
+}
+
+func TestPackageIdentCompare(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"core/node/8.9.0/20171101000000", "core/node/8.9.0/20171101000000", 0},
+		{"core/node/8.9.0/20171101000000", "core/node/10.0.0/20171101000000", -1},
+		{"core/node/8.10.0/20171101000000", "core/node/8.9.0/20171101000000", 1},
+		{"core/node/8.9.0/20171201000000", "core/node/8.9.0/20171101000000", 1},
+		{"core/node/8.9/20171201000000", "core/node/8.9.0/20171101000000", 1},
+		{"core/node/8.9.0", "core/node/8.9.0/20171101000000", -1},
+	}
+
+	for _, test := range tests {
+		a, _ := ParseIdent(test.a)
+		b, _ := ParseIdent(test.b)
+		if c := a.Compare(b); c != test.expected {
+			t.Errorf("Compare(%s, %s) = %d, expected %d", test.a, test.b, c, test.expected)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package hab
+
+import (
+	"context"
+	"errors"
+	"net/url"
+)
+
+// Searcher is a depot which searches packages by their names.
+type Searcher interface {
+	// Search returns the packages whose origin/name contains term, without versions.
+	Search(ctx context.Context, term string) ([]PackageIdent, error)
+}
+
+// Search fetches the packages whose origin/name contains term from the search endpoint of depot.
+func (depo *depot) Search(ctx context.Context, term string) ([]PackageIdent, error) {
+	// the search results are paged like the listing of a package
+	pages, err := depo.pages(ctx, "search/"+url.PathEscape(term))
+	if errors.Is(err, ErrPackageNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []PackageInfo
+	for _, page := range pages {
+		for _, pkg := range page.PackageList {
+			if onTarget(pkg, depo.target) {
+				packages = append(packages, pkg)
+			}
+		}
+	}
+	return uniquePackages(packages), nil
+}
+
+// uniquePackages returns the origin/name of packages without duplicates in their order.
+func uniquePackages(packages []PackageInfo) []PackageIdent {
+	var idents []PackageIdent
+	found := map[string]bool{}
+	for _, pkg := range packages {
+		ident := PackageIdent{Origin: pkg.Origin, Name: pkg.Name}
+		if found[ident.String()] {
+			continue
+		}
+		found[ident.String()] = true
+		idents = append(idents, ident)
+	}
+	return idents
+}
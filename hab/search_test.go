@@ -0,0 +1,57 @@
+package hab
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDirDepotSearch(t *testing.T) {
+	installedDir := t.TempDir()
+	for _, ident := range []string{"core/node/8.9.0/20171101000000", "core/node/8.10.0/20180301000000", "core/nodejs-tools/1.0.0/20180301000000", "foo/node-app/0.1.0/20180301000000", "core/yarn/1.3.2/20171116203524"} {
+		if err := os.MkdirAll(filepath.Join(installedDir, filepath.FromSlash(ident)), 0755); err != nil {
+			t.Fatalf("Unable to create package directory: %v", err)
+		}
+	}
+
+	for _, hart := range []string{"bar-node-gyp-3.6.2-20180101000000-x86_64-linux.hart", "core-node-9.0.0-20180401000000.hart", "node-notes.hart"} {
+		writeTestFile(t, filepath.Join(installedDir, hart), "")
+	}
+
+	indexDir := t.TempDir()
+	writeTestFile(t, filepath.Join(indexDir, indexFileName), `[
+		{"origin": "foo", "name": "test", "version": "0.0.1", "release": "20170524100001"},
+		{"origin": "foo", "name": "test", "version": "0.0.2", "release": "20170524100002"},
+		{"origin": "bar", "name": "tester", "version": "0.0.3", "release": "20170524100003"}
+	]`)
+	writeTestFile(t, filepath.Join(indexDir, channelsFileName), `{"baz/testing/1.0.0/20170524100004": ["stable"]}`)
+
+	tests := []struct {
+		dir      string
+		term     string
+		expected []string
+	}{
+		{installedDir, "node", []string{"bar/node-gyp", "core/node", "core/nodejs-tools", "foo/node-app"}},
+		{installedDir, "gyp", []string{"bar/node-gyp"}},
+		{installedDir, "core/node", []string{"core/node", "core/nodejs-tools"}},
+		{installedDir, "python", nil},
+		{indexDir, "test", []string{"bar/tester", "baz/testing", "foo/test"}},
+	}
+
+	for _, test := range tests {
+		idents, err := NewDirDepot(test.dir).(Searcher).Search(context.Background(), test.term)
+		if err != nil {
+			t.Errorf("Search(%q) error = %v, should be nil", test.term, err)
+			continue
+		}
+		var names []string
+		for _, ident := range idents {
+			names = append(names, ident.String())
+		}
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("Search(%q) = %v, expected %v", test.term, names, test.expected)
+		}
+	}
+}
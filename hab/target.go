@@ -0,0 +1,72 @@
+package hab
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"runtime"
+	"strings"
+)
+
+// Targets are the package targets which Habitat builds packages for.
+var Targets = []string{"x86_64-linux", "x86_64-linux-kernel2", "aarch64-linux", "x86_64-darwin", "x86_64-windows"}
+
+// targetArchs are the names of the architectures of Go in package targets.
+var targetArchs = map[string]string{
+	"amd64": "x86_64",
+	"arm64": "aarch64",
+}
+
+// osReleaseFile tells the version of the Linux kernel.
+var osReleaseFile = "/proc/sys/kernel/osrelease"
+
+// HostTarget returns the package target of the host, or an empty string if Habitat has none for it.
+func HostTarget() string {
+	arch, ok := targetArchs[runtime.GOARCH]
+	if !ok {
+		return ""
+	}
+	target := arch + "-" + runtime.GOOS
+	if target == "x86_64-linux" {
+		if release, err := ioutil.ReadFile(osReleaseFile); err == nil && strings.HasPrefix(string(release), "2.") {
+			target = "x86_64-linux-kernel2"
+		}
+	}
+	if ValidateTarget(target) != nil {
+		return ""
+	}
+	return target
+}
+
+// ValidateTarget checks if target is one of Targets.
+func ValidateTarget(target string) error {
+	for _, t := range Targets {
+		if t == target {
+			return nil
+		}
+	}
+	return fmt.Errorf("%v is invalid target, it must be one of %s", target, strings.Join(Targets, ", "))
+}
+
+// WithTarget lists only the packages built for target, and asks the depot for them.
+// Packages are not filtered by target if it is empty.
+func WithTarget(target string) Option {
+	return func(o *options) {
+		o.target = target
+	}
+}
+
+// targetQuery returns path with the target query which the depot finds the latest release on
+// the target with.
+func (depo *depot) targetQuery(path string) string {
+	if depo.target == "" {
+		return path
+	}
+	return path + "?target=" + url.QueryEscape(depo.target)
+}
+
+// onTarget checks if pkg is built for target. A package without a target is on every target,
+// and every package is on an empty target.
+func onTarget(pkg PackageInfo, target string) bool {
+	return target == "" || pkg.Target == "" || pkg.Target == target
+}
@@ -0,0 +1,87 @@
+package hab
+
+import (
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestHostTarget(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("the kernel version only matters on x86_64-linux")
+	}
+	defer func(file string) { osReleaseFile = file }(osReleaseFile)
+
+	dir := t.TempDir()
+	tests := map[string]string{
+		"5.15.0-91-generic\n":     "x86_64-linux",
+		"2.6.32-754.el6.x86_64\n": "x86_64-linux-kernel2",
+	}
+	for release, expected := range tests {
+		osReleaseFile = filepath.Join(dir, "osrelease")
+		writeTestFile(t, osReleaseFile, release)
+		if target := HostTarget(); target != expected {
+			t.Errorf("HostTarget() with kernel %q = %q, expected %q", release, target, expected)
+		}
+	}
+}
+
+func TestValidateTarget(t *testing.T) {
+	for _, target := range Targets {
+		if err := ValidateTarget(target); err != nil {
+			t.Errorf("ValidateTarget(%q) error = %v, should be nil", target, err)
+		}
+	}
+	for _, target := range []string{"", "x86_64", "arm64-linux"} {
+		if err := ValidateTarget(target); err == nil {
+			t.Errorf("Expected error for %q", target)
+		}
+	}
+}
+
+func TestDirDepotTarget(t *testing.T) {
+	hartDir := t.TempDir()
+	for _, hart := range []string{
+		"foo-test-0.0.1-20170524100001-x86_64-linux.hart",
+		"foo-test-0.0.1-20170524100001-aarch64-linux.hart",
+		"foo-test-0.0.2-20170524100002-x86_64-linux.hart",
+		"foo-test-0.0.3-20170524100003-aarch64-linux.hart",
+		"foo-test-0.0.4-20170524100004-x86_64-linux-kernel2.hart",
+	} {
+		writeTestFile(t, filepath.Join(hartDir, hart), "")
+	}
+
+	installedDir := t.TempDir()
+	writeTestFile(t, filepath.Join(installedDir, "foo", "test", "0.0.1", "20170524100001", "TARGET"), "aarch64-linux\n")
+	writeTestFile(t, filepath.Join(installedDir, "foo", "test", "0.0.2", "20170524100002", "TARGET"), "x86_64-linux\n")
+	writeTestFile(t, filepath.Join(installedDir, "foo", "test", "0.0.3", "20170524100003", "IDENT"), "")
+
+	tests := []struct {
+		dir      string
+		target   string
+		expected []string
+	}{
+		{hartDir, "x86_64-linux", []string{"0.0.1", "0.0.2"}},
+		{hartDir, "aarch64-linux", []string{"0.0.1", "0.0.3"}},
+		{hartDir, "x86_64-linux-kernel2", []string{"0.0.4"}},
+		{hartDir, "", []string{"0.0.1", "0.0.2", "0.0.3", "0.0.4"}},
+		{installedDir, "aarch64-linux", []string{"0.0.1", "0.0.3"}},
+		{installedDir, "x86_64-linux", []string{"0.0.2", "0.0.3"}},
+	}
+
+	for _, test := range tests {
+		depot, err := Open("file://"+filepath.ToSlash(test.dir), WithTarget(test.target))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		versions, err := depot.PackageVersionsFromName("foo/test", "")
+		if err != nil {
+			t.Errorf("%s on %q: error = %v, should be nil", test.dir, test.target, err)
+			continue
+		}
+		if !reflect.DeepEqual(versions, test.expected) {
+			t.Errorf("%s on %q: expected %v, actual %v", test.dir, test.target, test.expected, versions)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package hab
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidConstraint is matched by the errors of constraints which cannot be parsed.
+var ErrInvalidConstraint = errors.New("invalid constraint")
+
+// versionSplitter splits a version into its numeric part and extension like hab does.
+var versionSplitter = regexp.MustCompile(`([\d\.]+)(.+)?`)
+
+// splitVersion returns the numeric parts and the extension of version, e.g. [1 0 2] and "k"
+// for 1.0.2k. The leading - of an extension is removed, so 1.0.0-rc1 has the extension "rc1".
+func splitVersion(version string) ([]uint64, string, bool, error) {
+	caps := versionSplitter.FindStringSubmatch(version)
+	if caps == nil {
+		return nil, "", false, fmt.Errorf("%v is invalid version", version)
+	}
+
+	var parts []uint64
+	for _, part := range strings.Split(caps[1], ".") {
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return nil, "", false, fmt.Errorf("%v is invalid version", version)
+		}
+		parts = append(parts, n)
+	}
+
+	extension := caps[2]
+	if len(extension) > 1 && extension[0] == '-' {
+		extension = extension[1:]
+	}
+	return parts, extension, caps[2] != "", nil
+}
+
+// VersionParts returns the numeric parts of version, e.g. [1 0 2] for 1.0.2k.
+func VersionParts(version string) ([]uint64, error) {
+	parts, _, _, err := splitVersion(version)
+	return parts, err
+}
+
+// compareParts compares numeric parts, where missing parts count as 0.
+func compareParts(a, b []uint64) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var aNum, bNum uint64
+		if i < len(a) {
+			aNum = a[i]
+		}
+		if i < len(b) {
+			bNum = b[i]
+		}
+		if aNum < bNum {
+			return -1
+		}
+		if aNum > bNum {
+			return 1
+		}
+	}
+	return 0
+}
+
+// CompareVersions returns -1, 0 or 1 if a is older than, the same as or newer than b for hab.
+func CompareVersions(a, b string) int {
+	aParts, aExt, aHasExt, aErr := splitVersion(a)
+	bParts, bExt, bHasExt, bErr := splitVersion(b)
+	if aErr != nil || bErr != nil {
+		return strings.Compare(a, b)
+	}
+
+	if c := compareParts(aParts, bParts); c != 0 {
+		return c
+	}
+	// an extension like 1.0.0-rc1 or 1.0.2k comes before the version without one
+	switch {
+	case aHasExt && !bHasExt:
+		return -1
+	case !aHasExt && bHasExt:
+		return 1
+	}
+	return strings.Compare(aExt, bExt)
+}
+
+// constraintTerm matches a comparison like >=1.0.2k, ~9.5 or 2017.11.x in a constraint.
+var constraintTerm = regexp.MustCompile(`(!=|>=|<=|=|>|<|~|\^)?\s*(\d[0-9A-Za-z_.+*-]*|[*xX])`)
+
+// comparison checks a version against one term of a constraint.
+type comparison struct {
+	operator string
+	version  string
+	parts    []uint64
+	// wildcard is set when the version ends with x, X or *, which matches any remaining parts
+	wildcard bool
+}
+
+// Constraint is a semver-like version constraint which accepts versions like 2017.11.1 or 1.0.2k.
+type Constraint struct {
+	groups [][]comparison
+}
+
+// NewConstraint parses a constraint like >=1.0.2k, <1.1 || ~2017.11.
+func NewConstraint(expression string) (*Constraint, error) {
+	var c Constraint
+	for _, group := range strings.Split(expression, "||") {
+		if rest := strings.Trim(constraintTerm.ReplaceAllString(group, ""), " ,"); rest != "" {
+			return nil, fmt.Errorf("%v is %w", expression, ErrInvalidConstraint)
+		}
+
+		var comparisons []comparison
+		for _, m := range constraintTerm.FindAllStringSubmatch(group, -1) {
+			cmp, err := newComparison(m[1], m[2])
+			if err != nil {
+				return nil, fmt.Errorf("%v is %w: %v", expression, ErrInvalidConstraint, err)
+			}
+			comparisons = append(comparisons, cmp)
+		}
+		if len(comparisons) == 0 {
+			return nil, fmt.Errorf("%v is %w", expression, ErrInvalidConstraint)
+		}
+		c.groups = append(c.groups, comparisons)
+	}
+	return &c, nil
+}
+
+// newComparison parses the version of a comparison with operator.
+func newComparison(operator, version string) (comparison, error) {
+	cmp := comparison{operator: operator, version: version}
+
+	var numbers []string
+	for _, part := range strings.Split(version, ".") {
+		if part == "x" || part == "X" || part == "*" {
+			cmp.wildcard = true
+			break
+		}
+		numbers = append(numbers, part)
+	}
+	if cmp.wildcard {
+		if operator != "" && operator != "=" {
+			return cmp, fmt.Errorf("wildcard is not allowed with %s", operator)
+		}
+		for _, number := range numbers {
+			n, err := strconv.ParseUint(number, 10, 64)
+			if err != nil {
+				return cmp, fmt.Errorf("%v is invalid version", version)
+			}
+			cmp.parts = append(cmp.parts, n)
+		}
+		return cmp, nil
+	}
+
+	parts, _, _, err := splitVersion(version)
+	if err != nil {
+		return cmp, err
+	}
+	cmp.parts = parts
+	return cmp, nil
+}
+
+// hasPrefix checks if the numeric parts of version start with prefix.
+func hasPrefix(version string, prefix []uint64) bool {
+	parts, _, _, err := splitVersion(version)
+	if err != nil || len(parts) < len(prefix) {
+		return false
+	}
+	return compareParts(parts[:len(prefix)], prefix) == 0
+}
+
+// check checks if version satisfies the comparison.
+func (cmp comparison) check(version string) bool {
+	if cmp.wildcard {
+		return hasPrefix(version, cmp.parts)
+	}
+
+	c := CompareVersions(version, cmp.version)
+	switch cmp.operator {
+	case "", "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case "~":
+		// patch level changes: ~1.2.3 allows 1.2.*, and ~1.2 allows 1.2.*
+		n := len(cmp.parts)
+		if n > 2 {
+			n--
+		}
+		return c >= 0 && hasPrefix(version, cmp.parts[:n])
+	case "^":
+		// changes which keep the first non-zero part: ^1.2.3 allows 1.*, and ^0.2.3 allows 0.2.*
+		n := len(cmp.parts)
+		for i, part := range cmp.parts {
+			if part != 0 {
+				n = i + 1
+				break
+			}
+		}
+		return c >= 0 && hasPrefix(version, cmp.parts[:n])
+	}
+	return false
+}
+
+// Check checks if version satisfies the constraint.
+func (c *Constraint) Check(version string) bool {
+	for _, group := range c.groups {
+		matched := true
+		for _, cmp := range group {
+			if !cmp.check(version) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
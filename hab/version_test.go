@@ -0,0 +1,87 @@
+package hab
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"9.5", "9.5.0", 0},
+		{"8.10.0", "8.9.0", 1},
+		{"2017.11.1", "2017.2.10", 1},
+		{"1.2.3.4", "1.2.3", 1},
+		{"1.0.2k", "1.0.2", -1},
+		{"1.0.2k", "1.0.2j", 1},
+		{"1.0.2k", "1.0.1", 1},
+		{"1.0.0-rc1", "1.0.0", -1},
+		{"1.0.0-rc2", "1.0.0-rc1", 1},
+		{"master", "1.0.0", 1},
+	}
+
+	for _, test := range tests {
+		if c := CompareVersions(test.a, test.b); c != test.expected {
+			t.Errorf("CompareVersions(%q, %q) = %d, expected %d", test.a, test.b, c, test.expected)
+		}
+		if c := CompareVersions(test.b, test.a); c != -test.expected {
+			t.Errorf("CompareVersions(%q, %q) = %d, expected %d", test.b, test.a, c, -test.expected)
+		}
+	}
+}
+
+func TestConstraintCheck(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		expected   bool
+	}{
+		{"1.0.2k", "1.0.2k", true},
+		{"=9.5", "9.5.0", true},
+		{"!=1.0.2k", "1.0.2j", true},
+		{">=1.0.2k", "1.0.2l", true},
+		{">=1.0.2k", "1.0.2", true},
+		{">=1.0.2k", "1.0.2j", false},
+		{"<1.1", "1.0.2k", true},
+		{">= 1.0.2, < 1.1", "1.0.2k", false},
+		{">1.0.1 <1.1", "1.0.2k", true},
+		{"~1.0.1", "1.0.2k", true},
+		{"~1.0.2", "1.0.2k", false},
+		{"~1.0.2", "1.1.0", false},
+		{"~9.5", "9.5.4", true},
+		{"~9.5", "9.6", false},
+		{"~2017.11.1", "2017.11.5", true},
+		{"~2017.11.1", "2017.12.1", false},
+		{"^9.5", "9.6.1", true},
+		{"^9.5", "10.0", false},
+		{"^0.2.3", "0.2.9", true},
+		{"^0.2.3", "0.3.0", false},
+		{"2017.11.x", "2017.11.30", true},
+		{"2017.*", "2018.1.1", false},
+		{"x", "1.2.3", true},
+		{"<9.5 || ~10.1", "10.1.2", true},
+		{"<9.5 || ~10.1", "9.6", false},
+	}
+
+	for _, test := range tests {
+		c, err := NewConstraint(test.constraint)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", test.constraint, err)
+			continue
+		}
+		if actual := c.Check(test.version); actual != test.expected {
+			t.Errorf("Check(%q) of %q = %v, expected %v", test.version, test.constraint, actual, test.expected)
+		}
+	}
+}
+
+func TestNewConstraintError(t *testing.T) {
+	for _, constraint := range []string{"", "latest", "v1.0", ">=", "1.0 ||", ">2017.x", "1..2"} {
+		if _, err := NewConstraint(constraint); !errors.Is(err, ErrInvalidConstraint) {
+			t.Errorf("Expected ErrInvalidConstraint for %q, actual %v", constraint, err)
+		}
+	}
+}
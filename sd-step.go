@@ -1,168 +1,180 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
-	"os/exec"
-	"os/user"
-	"regexp"
+	"os/signal"
+	"path/filepath"
 	"runtime/debug"
-	"sort"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
-	"github.com/Masterminds/semver"
 	"github.com/screwdriver-cd/sd-step/hab"
+	"github.com/screwdriver-cd/sd-step/step"
 	"github.com/urfave/cli"
 )
 
 // VERSION gets set by the build script via the LDFLAGS.
 var VERSION string
 
-// habDepotURL is base url for public depot of habitat.
-const habDepotURL = "https://willem.habitat.sh/v1/depot"
-
-var habPath = "/opt/sd/bin/hab"
-var versionValidator = regexp.MustCompile(`^\d+(\.\d+)*$`)
-var execCommand = exec.Command
-
 // successExit exits process with 0
 func successExit() {
 	os.Exit(0)
 }
 
-// failureExit exits process with 1.
+// failureExit exits process with the exit code for err.
 func failureExit(err error) {
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
 	}
-	os.Exit(1)
+	os.Exit(exitCode(err))
 }
 
-// finalRecover makes one last attempt to recover from a panic.
-// This should only happen if the previous recovery caused a panic.
-func finalRecover() {
-	if p := recover(); p != nil {
-		fmt.Fprintln(os.Stderr, "ERROR: Something terrible has happened. Please file a ticket with this info:")
-		fmt.Fprintf(os.Stderr, "ERROR: %v\n%v\n", p, debug.Stack())
-		failureExit(nil)
-	}
-	successExit()
+// resolutionExitCodes are the exit codes for the errors of resolving a package version.
+var resolutionExitCodes = []struct {
+	err  error
+	code int
+}{
+	{hab.ErrInvalidConstraint, 2},
+	{step.ErrNoMatchingVersion, 3},
+	{hab.ErrPackageNotFound, 4},
+	{hab.ErrDepotUnavailable, 5},
 }
 
-// translatePkgName translates the pkgName if pkgVersion is specified.
-func translatePkgName(pkgName string, pkgVersion string) (string, error) {
-	if pkgVersion == "" {
-		return pkgName, nil
+// exitCode returns the exit code of sd-step for err, which follows a shell and timeout(1).
+func exitCode(err error) int {
+	var interrupted *step.InterruptError
+	if errors.As(err, &interrupted) {
+		return 128 + int(interrupted.Signal)
 	}
-	if valid := versionValidator.MatchString(pkgVersion); valid {
-		return pkgName + "/" + pkgVersion, nil
+	var timedOut *step.TimeoutError
+	if errors.As(err, &timedOut) {
+		return 124
 	}
-	return "", fmt.Errorf("%v is invalid version", pkgVersion)
+	for _, resolution := range resolutionExitCodes {
+		if errors.Is(err, resolution.err) {
+			return resolution.code
+		}
+	}
+	return 1
 }
 
-// runCommand runs command.
-func runCommand(command string, output io.Writer) error {
-	cmd := execCommand("sh", "-c", command)
-	cmd.Stdout = output
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// interruptContext returns a context which the first forwarded signal cancels with an InterruptError.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		sig := <-sigs
+		signal.Stop(sigs)
+		cancel(&step.InterruptError{Signal: sig.(syscall.Signal)})
+	}()
+	return ctx
 }
 
-// isPackageInstalled checks if the package is installed.
-func isPackageInstalled(pkgName string, pkgVersion string) bool {
-	var output io.Writer
-
-	pkg, err := translatePkgName(pkgName, pkgVersion)
-	if err != nil {
-		return false
+// printMatrixSummary prints a table of the results of matrix and returns the number of failures.
+func printMatrixSummary(w io.Writer, results []step.MatrixResult) int {
+	failed := 0
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PACKAGE\tRESULT\tDURATION\tERROR")
+	for _, result := range results {
+		ident, _ := result.Package.Ident()
+		status, message := "pass", ""
+		if !result.Passed() {
+			failed++
+			status, message = "FAIL", result.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%v\t%s\n", ident, status, result.Duration.Round(time.Millisecond), message)
 	}
+	tw.Flush()
 
-	// hab pkg path command exits with zero if pkg exists
-	checkCmd := habPath + " pkg path " + pkg + " >/dev/null 2>&1"
-	checkCmdResult := runCommand(checkCmd, output)
-
-	return checkCmdResult == nil
+	return failed
 }
 
-// execHab installs habitat package and executes habitat command.
-func execHab(pkgName string, pkgVersion string, habChannel string, command []string, output io.Writer) error {
-	pkg, verErr := translatePkgName(pkgName, pkgVersion)
-	if verErr != nil {
-		return verErr
-	}
-
-	if !isPackageInstalled(pkgName, pkgVersion) {
-		installCmd := []string{habPath, "pkg", "install", pkg, "-c", habChannel, ">/dev/null"}
-		if u, userErr := user.Current(); userErr != nil || u.Uid != "0" {
-			// execute sudo command if not root user
-			installCmd = append([]string{"sudo"}, installCmd...)
-		}
-
-		unwrappedInstallCommand := strings.Join(installCmd, " ")
-		installErr := runCommand(unwrappedInstallCommand, output)
-		if installErr != nil {
-			return installErr
-		}
+// formatSize returns size in bytes in the largest binary unit which keeps it at least 1.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
 	}
-
-	execCmd := []string{habPath, "pkg", "exec", pkg}
-	unwrappedExecCommand := strings.Join(append(execCmd, command...), " ")
-	execErr := runCommand(unwrappedExecCommand, output)
-	if execErr != nil {
-		return execErr
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
 	}
-
-	return nil
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
 }
 
-// getPackageVersion returns the appropriate package version which matched the `pkgVerExp` expression.
-func getPackageVersion(depot hab.Depot, pkgName, pkgVerExp string, habChannel string) (string, error) {
-	versionConst, err := semver.NewConstraint(pkgVerExp)
-	// if pkgVerExp is invalid for semver expression, it returns pkgVerExp as it is
-	if err != nil {
-		return pkgVerExp, nil
+// printInfo prints the metadata of a package, as JSON if asJSON is true.
+func printInfo(w io.Writer, info step.Info, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
 	}
 
-	foundVersions, err := depot.PackageVersionsFromName(pkgName, habChannel)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: Unable to access to Habitat depot API. %v\n"+
-			"Trying to fetch versions from installed packages...\n", err)
-		dirs, err := ioutil.ReadDir("/hab/pkgs/" + pkgName)
-		if err != nil {
-			return "", errors.New("the specified version not found")
-		}
-		for _, dir := range dirs {
-			foundVersions = append(foundVersions, dir.Name())
+	orNone := func(s string) string {
+		if s == "" {
+			return "(unknown)"
 		}
+		return s
+	}
+	installed := "no"
+	if info.Installed {
+		installed = fmt.Sprintf("yes, %s in %s", formatSize(info.Size), info.Path)
 	}
 
-	var versions []*semver.Version
-	for _, version := range foundVersions {
-		// if version exactly matches pkgVersionExp, it returns the version
-		if version == pkgVerExp {
-			return version, nil
-		}
-
-		v, err := semver.NewVersion(version)
-		if err != nil {
-			continue
-		}
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(tw, "Ident:\t%s\n", info.Ident())
+	fmt.Fprintf(tw, "Origin:\t%s\n", info.Origin)
+	fmt.Fprintf(tw, "Name:\t%s\n", info.Name)
+	fmt.Fprintf(tw, "Version:\t%s\n", info.Version)
+	fmt.Fprintf(tw, "Release:\t%s\n", info.Release)
+	fmt.Fprintf(tw, "Channels:\t%s\n", orNone(strings.Join(info.Channels, ", ")))
+	fmt.Fprintf(tw, "Target:\t%s\n", orNone(info.Target))
+	fmt.Fprintf(tw, "Checksum:\t%s\n", orNone(info.Checksum))
+	fmt.Fprintf(tw, "Build date:\t%s\n", orNone(info.BuildDate))
+	fmt.Fprintf(tw, "Installed:\t%s\n", installed)
+	fmt.Fprintf(tw, "Dependencies:\t%d (%d transitive)\n", len(info.Deps), len(info.TDeps))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, dep := range info.Deps {
+		fmt.Fprintf(w, "  %s\n", dep)
+	}
+	return nil
+}
 
-		if versionConst.Check(v) {
-			versions = append(versions, v)
+// printSearchResults prints a table of packages with their latest releases.
+func printSearchResults(w io.Writer, results []step.Info) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PACKAGE\tVERSION\tRELEASE")
+	for _, result := range results {
+		version, release := result.Version, result.Release
+		if version == "" {
+			version, release = "-", "-"
 		}
+		fmt.Fprintf(tw, "%s/%s\t%s\t%s\n", result.Origin, result.Name, version, release)
 	}
+	tw.Flush()
+}
 
-	if len(versions) == 0 {
-		return "", errors.New("the specified version not found")
+// finalRecover makes one last attempt to recover from a panic.
+// This should only happen if the previous recovery caused a panic.
+func finalRecover() {
+	if p := recover(); p != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: Something terrible has happened. Please file a ticket with this info:")
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n%v\n", p, debug.Stack())
+		failureExit(nil)
 	}
-
-	sort.Sort(sort.Reverse(semver.Collection(versions)))
-
-	return versions[0].String(), nil
+	successExit()
 }
 
 func main() {
@@ -170,8 +182,7 @@ func main() {
 
 	var pkgVerExp string
 	var habChannel string
-	var pkgVersion string
-	var err error
+	cfg := step.DefaultConfig()
 
 	app := cli.NewApp()
 	app.Name = "sd-step"
@@ -193,10 +204,105 @@ func main() {
 		},
 		cli.StringFlag{
 			Name:        "hab-channel",
-			Usage:       "Install from the specified release channel",
+			Usage:       "Install from the specified release channel, or the first of comma separated channels which has the version",
 			Value:       "stable",
 			Destination: &habChannel,
 		},
+		cli.StringFlag{
+			Name:        "depot-url",
+			Usage:       "Depot to resolve package versions from, or file:///path for a local directory",
+			Value:       cfg.DepotURL,
+			Destination: &cfg.DepotURL,
+		},
+		cli.StringFlag{
+			Name:        "hab-path",
+			Usage:       "Path of the hab command",
+			Value:       cfg.HabPath,
+			Destination: &cfg.HabPath,
+		},
+		cli.StringFlag{
+			Name:        "hab-root",
+			Usage:       "Filesystem root to install packages into, e.g. $HOME/.sd-step/hab",
+			EnvVar:      "FS_ROOT",
+			Destination: &cfg.HabRoot,
+		},
+		cli.StringFlag{
+			Name:        "target",
+			Usage:       "Platform to resolve and install packages for: " + strings.Join(hab.Targets, ", ") + ", all targets if empty",
+			Value:       cfg.Target,
+			EnvVar:      "HAB_TARGET",
+			Destination: &cfg.Target,
+		},
+		cli.StringFlag{
+			Name:        "privilege",
+			Usage:       "How to install packages as non-root user: auto, sudo, sudo-n, doas or none",
+			Value:       cfg.Privilege,
+			Destination: &cfg.Privilege,
+		},
+		cli.BoolFlag{
+			Name:        "allow-prerelease",
+			Usage:       "Let version constraints match prereleases like 1.2.3-abc",
+			Destination: &cfg.AllowPrerelease,
+		},
+		cli.StringFlag{
+			Name:        "strategy",
+			Usage:       "Version to choose among the ones matching --pkg-version: highest, lowest or newest (most recently released)",
+			Value:       cfg.Strategy,
+			Destination: &cfg.Strategy,
+		},
+		cli.BoolFlag{
+			Name:        "explain",
+			Usage:       "Show every version considered for --pkg-version and why it was accepted or rejected",
+			Destination: &cfg.Explain,
+		},
+		cli.StringFlag{
+			Name:        "fallback",
+			Usage:       "Where to resolve versions from when the depot is unavailable: never, installed (packages) or cache (of the versions the depot listed last time)",
+			Value:       cfg.Fallback,
+			Destination: &cfg.Fallback,
+		},
+		cli.DurationFlag{
+			Name:        "grace-period",
+			Usage:       "Time to wait for an interrupted command to exit before killing it",
+			Value:       cfg.GracePeriod,
+			Destination: &cfg.GracePeriod,
+		},
+		cli.DurationFlag{
+			Name:        "depot-timeout",
+			Usage:       "Time limit for each request to the depot, no limit if zero",
+			Value:       cfg.DepotTimeout,
+			Destination: &cfg.DepotTimeout,
+		},
+		cli.DurationFlag{
+			Name:        "install-timeout",
+			Usage:       "Time limit for installing the package, no limit if zero",
+			Destination: &cfg.InstallTimeout,
+		},
+		cli.DurationFlag{
+			Name:        "exec-timeout",
+			Usage:       "Time limit for executing the command, no limit if zero",
+			Destination: &cfg.ExecTimeout,
+		},
+	}
+
+	// newStep returns the step for the parsed options, or exits if they are invalid.
+	newStep := func() *step.Step {
+		// hab and proot need an absolute path as the filesystem root
+		if cfg.HabRoot != "" {
+			root, err := filepath.Abs(cfg.HabRoot)
+			if err != nil {
+				failureExit(err)
+			}
+			cfg.HabRoot = root
+		}
+
+		cfg.UserAgent = "sd-step/" + VERSION
+		cfg.Context = interruptContext()
+		s, err := step.New(cfg)
+		if err != nil {
+			failureExit(err)
+		}
+		return s
 	}
 
 	app.Commands = []cli.Command{
@@ -210,27 +316,226 @@ func main() {
 
 				pkgName := c.Args().Get(0)
 
-				depot := hab.New(habDepotURL)
+				err := newStep().Exec(pkgName, pkgVerExp, habChannel, c.Args().Tail(), os.Stdout)
+				if err != nil {
+					failureExit(err)
+				}
+				successExit()
+				return nil
+			},
+			Flags: app.Flags,
+		},
+		{
+			Name:      "resolve",
+			Usage:     "Print the package identifier which pkg_name and --pkg-version resolve to",
+			ArgsUsage: "pkg_name",
+			Action: func(c *cli.Context) error {
+				if len(c.Args()) != 1 {
+					return cli.ShowCommandHelp(c, "resolve")
+				}
+				cfg.Verbose = c.Bool("verbose")
+
+				// an empty expression leaves the latest version to hab, so resolve it here
+				verExp := pkgVerExp
+				if verExp == "" {
+					verExp = "*"
+				}
+
+				pkg, err := newStep().Resolver.Resolve(c.Args().Get(0), verExp, habChannel)
+				if err != nil {
+					failureExit(fmt.Errorf("failed to get package version: %w", err))
+				}
+				ident, err := pkg.Ident()
+				if err != nil {
+					failureExit(err)
+				}
+				fmt.Println(ident)
+				successExit()
+				return nil
+			},
+			Flags: append([]cli.Flag{
+				cli.BoolFlag{
+					Name:  "verbose",
+					Usage: "Show the channel and the prereleases which are excluded from matches",
+				},
+			}, app.Flags...),
+		},
+		{
+			Name:      "info",
+			Usage:     "Print the metadata of the habitat package which pkg_name and --pkg-version resolve to",
+			ArgsUsage: "pkg_name",
+			Action: func(c *cli.Context) error {
+				if len(c.Args()) != 1 {
+					return cli.ShowCommandHelp(c, "info")
+				}
+
+				info, err := newStep().Info(c.Args().Get(0), pkgVerExp, habChannel)
+				if err != nil {
+					failureExit(err)
+				}
+				if err := printInfo(os.Stdout, info, c.Bool("json")); err != nil {
+					failureExit(err)
+				}
+				successExit()
+				return nil
+			},
+			Flags: append([]cli.Flag{
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "Print the metadata as JSON",
+				},
+			}, app.Flags...),
+		},
+		{
+			Name:      "search",
+			Usage:     "Search the depot for habitat packages whose origin/name contains term",
+			ArgsUsage: "term",
+			Action: func(c *cli.Context) error {
+				if len(c.Args()) != 1 {
+					return cli.ShowCommandHelp(c, "search")
+				}
+				channel := habChannel
+				if c.IsSet("channel") {
+					channel = c.String("channel")
+				}
 
-				// Use verExp as an exact package version if it is already installed
-				if isPackageInstalled(pkgName, pkgVerExp) {
-					pkgVersion = pkgVerExp
-				} else if pkgVerExp != "" {
-					pkgVersion, err = getPackageVersion(depot, pkgName, pkgVerExp, habChannel)
+				results, err := newStep().Inspector.Search(c.Args().Get(0), c.String("origin"), channel)
+				if err != nil {
+					failureExit(fmt.Errorf("failed to search packages: %w", err))
+				}
+				if len(results) == 0 {
+					fmt.Fprintf(os.Stderr, "No packages found for %s\n", c.Args().Get(0))
+					successExit()
+				}
+				printSearchResults(os.Stdout, results)
+				successExit()
+				return nil
+			},
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "origin",
+					Usage: "Only list the packages of the origin",
+				},
+				cli.StringFlag{
+					Name:  "channel",
+					Usage: "Channel to show the latest versions in, --hab-channel if not set",
+				},
+			}, app.Flags...),
+		},
+		{
+			Name:      "matrix",
+			Usage:     "Install each version of habitat package matching --pkg-version and exec command with it",
+			ArgsUsage: "pkg_name [--] command...",
+			Action: func(c *cli.Context) error {
+				if len(c.Args()) < 2 {
+					return cli.ShowCommandHelp(c, "matrix")
+				}
 
-					if err != nil {
-						failureExit(fmt.Errorf("failed to get package version: %v", err))
+				results, err := newStep().Matrix(c.Args().Get(0), pkgVerExp, habChannel,
+					c.String("select"), c.Int("parallel"), c.Args().Tail(), os.Stdout)
+				// an interrupted matrix exits with the exit code of the signal
+				if err != nil {
+					if results != nil {
+						printMatrixSummary(os.Stdout, results)
 					}
+					failureExit(err)
 				}
 
-				err = execHab(pkgName, pkgVersion, habChannel, c.Args().Tail(), os.Stdout)
+				if failed := printMatrixSummary(os.Stdout, results); failed > 0 {
+					failureExit(fmt.Errorf("%d of %d versions failed", failed, len(results)))
+				}
+				successExit()
+				return nil
+			},
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "select",
+					Usage: "Versions to run with: all, latest-major or latest-minor",
+					Value: "all",
+				},
+				cli.IntFlag{
+					Name:  "parallel",
+					Usage: "Number of versions to run the command with at the same time",
+					Value: 1,
+				},
+			}, app.Flags...),
+		},
+		{
+			Name:      "shell",
+			Usage:     "Install habitat packages and start an interactive shell with them on PATH",
+			ArgsUsage: "--pkg pkg_name[@pkg_version] [--pkg ...]",
+			Action: func(c *cli.Context) error {
+				specs := c.StringSlice("pkg")
+				if len(specs) == 0 {
+					return cli.ShowCommandHelp(c, "shell")
+				}
+
+				err := newStep().Shell(specs, habChannel)
 				if err != nil {
 					failureExit(err)
 				}
 				successExit()
 				return nil
 			},
-			Flags: app.Flags,
+			Flags: append([]cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "pkg",
+					Usage: "Package to add to the shell as pkg_name[@pkg_version], can be repeated",
+				},
+			}, app.Flags...),
+		},
+		{
+			Name:  "bundle",
+			Usage: "Create and install bundles of habitat packages for hosts without depot access",
+			Subcommands: []cli.Command{
+				{
+					Name:      "create",
+					Usage:     "Download habitat packages with their dependencies and origin keys into a bundle",
+					ArgsUsage: "--pkg pkg_name[@pkg_version] [--pkg ...] -o bundle.tar",
+					Action: func(c *cli.Context) error {
+						specs := c.StringSlice("pkg")
+						output := c.String("output")
+						if len(specs) == 0 || output == "" {
+							return cli.ShowCommandHelp(c, "create")
+						}
+
+						err := newStep().CreateBundle(specs, habChannel, output)
+						if err != nil {
+							failureExit(err)
+						}
+						successExit()
+						return nil
+					},
+					Flags: append([]cli.Flag{
+						cli.StringSliceFlag{
+							Name:  "pkg",
+							Usage: "Package to add to the bundle as pkg_name[@pkg_version], can be repeated",
+						},
+						cli.StringFlag{
+							Name:  "output, o",
+							Usage: "Path of the bundle to create",
+						},
+					}, app.Flags...),
+				},
+				{
+					Name:      "install",
+					Usage:     "Install habitat packages from a bundle without depot access",
+					ArgsUsage: "bundle.tar",
+					Action: func(c *cli.Context) error {
+						if len(c.Args()) != 1 {
+							return cli.ShowCommandHelp(c, "install")
+						}
+
+						err := newStep().Bundler.InstallBundle(c.Args().Get(0), os.Stdout)
+						if err != nil {
+							failureExit(err)
+						}
+						successExit()
+						return nil
+					},
+					Flags: app.Flags,
+				},
+			},
 		},
 	}
 
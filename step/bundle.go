@@ -0,0 +1,320 @@
+package step
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/screwdriver-cd/sd-step/hab"
+)
+
+// bundleManifestName is the name of the manifest in a bundle.
+const bundleManifestName = "manifest.json"
+
+// bundleManifest describes the contents of a bundle.
+type bundleManifest struct {
+	Packages []bundlePackage `json:"packages"`
+	// Artifacts are .hart files in the order of installation, dependencies come first.
+	Artifacts []string `json:"artifacts"`
+	Keys      []string `json:"keys"`
+}
+
+// bundlePackage is a package requested when the bundle was created.
+type bundlePackage struct {
+	Name    string `json:"name"`
+	Ident   string `json:"ident"`
+	Channel string `json:"channel"`
+}
+
+// CreateBundle downloads pkgs with their dependencies and origin public keys, and writes them
+// into the tar file `output` so that they can be installed without access to the depot.
+func (h *Hab) CreateBundle(pkgs []Package, output string) error {
+	workRoot, err := ioutil.TempDir("", "sd-step-bundle")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workRoot)
+
+	var manifest bundleManifest
+	for _, p := range pkgs {
+		pkg, verErr := p.Ident()
+		if verErr != nil {
+			return verErr
+		}
+
+		ctx, cancel := phaseContext(h.context(), h.InstallTimeout)
+		// a fresh filesystem root makes hab download every dependency into its artifact cache
+		installCmd := h.installCommand(workRoot, pkg.String(), p.Channel)
+		installErr := h.Runner.Run(ctx, strings.Join(installCmd, " "), h.stderr())
+		cancel()
+		if installErr != nil {
+			return phaseError("install", h.InstallTimeout, installErr)
+		}
+
+		ident, identErr := installedIdent(workRoot, pkg)
+		if identErr != nil {
+			return identErr
+		}
+		manifest.Packages = append(manifest.Packages, bundlePackage{p.Name, ident.String(), p.Channel})
+	}
+
+	artifacts, err := artifactsInInstallOrder(workRoot)
+	if err != nil {
+		return err
+	}
+	keys, err := filepath.Glob(filepath.Join(workRoot, "hab", "cache", "keys", "*.pub"))
+	if err != nil {
+		return err
+	}
+
+	files := map[string]string{}
+	for _, artifact := range artifacts {
+		name := "artifacts/" + filepath.Base(artifact)
+		manifest.Artifacts = append(manifest.Artifacts, name)
+		files[name] = artifact
+	}
+	for _, key := range keys {
+		name := "keys/" + filepath.Base(key)
+		manifest.Keys = append(manifest.Keys, name)
+		files[name] = key
+	}
+
+	return writeBundle(output, manifest, files)
+}
+
+// installedIdent returns the fully qualified identifier of pkg installed in root.
+// The latest installed release is used if pkg is not fully qualified.
+func installedIdent(root string, pkg hab.PackageIdent) (hab.PackageIdent, error) {
+	pattern := filepath.Join(root, "hab", "pkgs", pkg.Origin, pkg.Name, "*", "*")
+	if pkg.Version != "" {
+		pattern = filepath.Join(root, "hab", "pkgs", pkg.Origin, pkg.Name, pkg.Version, "*")
+	}
+
+	dirs, err := filepath.Glob(pattern)
+	if err != nil {
+		return hab.PackageIdent{}, err
+	}
+
+	var latest hab.PackageIdent
+	for _, dir := range dirs {
+		rel, err := filepath.Rel(filepath.Join(root, "hab", "pkgs"), dir)
+		if err != nil {
+			return hab.PackageIdent{}, err
+		}
+		ident, err := hab.ParseIdent(filepath.ToSlash(rel))
+		if err != nil || (pkg.Release != "" && ident.Release != pkg.Release) {
+			continue
+		}
+		if !latest.FullyQualified() || ident.Compare(latest) > 0 {
+			latest = ident
+		}
+	}
+	if !latest.FullyQualified() {
+		return hab.PackageIdent{}, fmt.Errorf("%s is not installed", pkg)
+	}
+	return latest, nil
+}
+
+// artifactsInInstallOrder returns the .hart files of all packages installed in root so that
+// every package comes after its dependencies.
+func artifactsInInstallOrder(root string) ([]string, error) {
+	pkgsRoot := filepath.Join(root, "hab", "pkgs")
+	dirs, err := filepath.Glob(filepath.Join(pkgsRoot, "*", "*", "*", "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	// transitive dependencies of a package are always fewer than the ones of its dependents
+	tdepCounts := map[string]int{}
+	for _, dir := range dirs {
+		tdeps, err := ioutil.ReadFile(filepath.Join(dir, "TDEPS"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		tdepCounts[dir] = len(strings.Fields(string(tdeps)))
+	}
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return tdepCounts[dirs[i]] < tdepCounts[dirs[j]]
+	})
+
+	var artifacts []string
+	for _, dir := range dirs {
+		rel, err := filepath.Rel(pkgsRoot, dir)
+		if err != nil {
+			return nil, err
+		}
+		ident, err := hab.ParseIdent(filepath.ToSlash(rel))
+		if err != nil {
+			return nil, err
+		}
+		prefix := strings.Join([]string{ident.Origin, ident.Name, ident.Version, ident.Release}, "-") + "-"
+		found, err := filepath.Glob(filepath.Join(root, "hab", "cache", "artifacts", prefix+"*.hart"))
+		if err != nil {
+			return nil, err
+		}
+		if len(found) == 0 {
+			return nil, fmt.Errorf("artifact of %s is not found", ident)
+		}
+		artifacts = append(artifacts, found[0])
+	}
+
+	return artifacts, nil
+}
+
+// writeBundle writes the manifest and files into the tar file `output`.
+func writeBundle(output string, manifest bundleManifest, files map[string]string) (err error) {
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	tw := tar.NewWriter(f)
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: bundleManifestName, Mode: 0644, Size: int64(len(manifestJSON))}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifestJSON); err != nil {
+		return err
+	}
+
+	names := append(append([]string{}, manifest.Artifacts...), manifest.Keys...)
+	for _, name := range names {
+		if err := addFileToTar(tw, name, files[name]); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// addFileToTar writes the file at src into tw as name.
+func addFileToTar(tw *tar.Writer, name, src string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: info.Size(), ModTime: info.ModTime()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
+
+// extractBundle extracts the bundle into dir and returns its manifest.
+func extractBundle(bundle, dir string) (bundleManifest, error) {
+	var manifest bundleManifest
+
+	f, err := os.Open(bundle)
+	if err != nil {
+		return manifest, err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, err
+		}
+
+		name := path.Clean(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return manifest, fmt.Errorf("unexpected entry in bundle: %s", hdr.Name)
+		}
+
+		dest := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return manifest, err
+		}
+		out, err := os.Create(dest)
+		if err != nil {
+			return manifest, err
+		}
+		_, err = io.Copy(out, tr)
+		out.Close()
+		if err != nil {
+			return manifest, err
+		}
+	}
+
+	manifestJSON, err := ioutil.ReadFile(filepath.Join(dir, bundleManifestName))
+	if err != nil {
+		return manifest, errors.New("manifest is not found in bundle")
+	}
+	err = json.Unmarshal(manifestJSON, &manifest)
+	return manifest, err
+}
+
+// InstallBundle imports the origin keys and installs the artifacts in bundle, so that the
+// packages can be executed without access to the depot.
+func (h *Hab) InstallBundle(bundle string, output io.Writer) error {
+	dir, err := ioutil.TempDir("", "sd-step-bundle")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	manifest, err := extractBundle(bundle, dir)
+	if err != nil {
+		return fmt.Errorf("failed to read bundle: %v", err)
+	}
+
+	prefix, err := h.privilegePrefix()
+	if err != nil {
+		return err
+	}
+
+	var commands []string
+	for _, key := range manifest.Keys {
+		keyPath := shellQuote(filepath.Join(dir, filepath.FromSlash(key)))
+		commands = append(commands, strings.Join(append(prefix, h.command("origin", "key", "import")...), " ")+" <"+keyPath)
+	}
+	for _, artifact := range manifest.Artifacts {
+		// dependencies come first, so hab never has to download them from the depot
+		artifactPath := shellQuote(filepath.Join(dir, filepath.FromSlash(artifact)))
+		commands = append(commands, strings.Join(append(prefix, h.command("pkg", "install", artifactPath, ">/dev/null")...), " "))
+	}
+
+	for _, command := range commands {
+		ctx, cancel := phaseContext(h.context(), h.InstallTimeout)
+		runErr := h.Runner.Run(ctx, command, output)
+		cancel()
+		if runErr != nil {
+			return phaseError("install", h.InstallTimeout, runErr)
+		}
+	}
+
+	for _, p := range manifest.Packages {
+		fmt.Fprintf(output, "Installed %s\n", p.Ident)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package step
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/screwdriver-cd/sd-step/hab"
+)
+
+// makeFakeHabRoot creates installed packages and their artifacts under root.
+// Each package is given as fully qualified ident with its transitive dependencies.
+func makeFakeHabRoot(t *testing.T, root string, pkgs map[string][]string) {
+	for ident, tdeps := range pkgs {
+		dir := filepath.Join(root, "hab", "pkgs", filepath.FromSlash(ident))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Unable to create package directory: %v", err)
+		}
+		if len(tdeps) > 0 {
+			if err := ioutil.WriteFile(filepath.Join(dir, "TDEPS"), []byte(strings.Join(tdeps, "\n")+"\n"), 0644); err != nil {
+				t.Fatalf("Unable to write TDEPS: %v", err)
+			}
+		}
+
+		artifacts := filepath.Join(root, "hab", "cache", "artifacts")
+		if err := os.MkdirAll(artifacts, 0755); err != nil {
+			t.Fatalf("Unable to create artifact cache: %v", err)
+		}
+		hart := strings.Replace(ident, "/", "-", -1) + "-x86_64-linux.hart"
+		if err := ioutil.WriteFile(filepath.Join(artifacts, hart), []byte(ident), 0644); err != nil {
+			t.Fatalf("Unable to write artifact: %v", err)
+		}
+	}
+}
+
+func TestArtifactsInInstallOrder(t *testing.T) {
+	root := t.TempDir()
+	makeFakeHabRoot(t, root, map[string][]string{
+		"core/node/8.9.0/20171101000000":     {"core/gcc-libs/5.2.0/20170513212920", "core/glibc/2.22/20170513201042"},
+		"core/gcc-libs/5.2.0/20170513212920": {"core/glibc/2.22/20170513201042"},
+		"core/glibc/2.22/20170513201042":     nil,
+	})
+
+	artifacts, err := artifactsInInstallOrder(root)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var names []string
+	for _, artifact := range artifacts {
+		names = append(names, filepath.Base(artifact))
+	}
+	expected := []string{
+		"core-glibc-2.22-20170513201042-x86_64-linux.hart",
+		"core-gcc-libs-5.2.0-20170513212920-x86_64-linux.hart",
+		"core-node-8.9.0-20171101000000-x86_64-linux.hart",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v, actual %v", expected, names)
+	}
+
+	node := hab.PackageIdent{Origin: "core", Name: "node"}
+	ident, err := installedIdent(root, node)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ident.String() != "core/node/8.9.0/20171101000000" {
+		t.Errorf("Expected core/node/8.9.0/20171101000000, actual %s", ident)
+	}
+	node.Version = "6.11.5"
+	if _, err := installedIdent(root, node); err == nil {
+		t.Errorf("Expected error for a version which is not installed")
+	}
+}
+
+func TestInstalledIdentIsLatest(t *testing.T) {
+	root := t.TempDir()
+	makeFakeHabRoot(t, root, map[string][]string{
+		"core/node/8.9.0/20171101000000":  nil,
+		"core/node/10.0.0/20180501000000": nil,
+		"core/node/10.0.0/20180601000000": nil,
+	})
+
+	tests := []struct {
+		pkg      hab.PackageIdent
+		expected string
+	}{
+		{hab.PackageIdent{Origin: "core", Name: "node"}, "core/node/10.0.0/20180601000000"},
+		{hab.PackageIdent{Origin: "core", Name: "node", Version: "8.9.0"}, "core/node/8.9.0/20171101000000"},
+		{hab.PackageIdent{Origin: "core", Name: "node", Version: "10.0.0", Release: "20180501000000"}, "core/node/10.0.0/20180501000000"},
+	}
+
+	for _, test := range tests {
+		ident, err := installedIdent(root, test.pkg)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if ident.String() != test.expected {
+			t.Errorf("Expected %s for %s, actual %s", test.expected, test.pkg, ident)
+		}
+	}
+}
+
+func TestWriteAndExtractBundle(t *testing.T) {
+	root := t.TempDir()
+	makeFakeHabRoot(t, root, map[string][]string{
+		"core/glibc/2.22/20170513201042": nil,
+	})
+	keyPath := filepath.Join(root, "core-20160810182414.pub")
+	if err := ioutil.WriteFile(keyPath, []byte("SIG-PUB-1"), 0644); err != nil {
+		t.Fatalf("Unable to write key: %v", err)
+	}
+
+	manifest := bundleManifest{
+		Packages:  []bundlePackage{{"core/glibc", "core/glibc/2.22/20170513201042", "stable"}},
+		Artifacts: []string{"artifacts/core-glibc-2.22-20170513201042-x86_64-linux.hart"},
+		Keys:      []string{"keys/core-20160810182414.pub"},
+	}
+	files := map[string]string{
+		manifest.Artifacts[0]: filepath.Join(root, "hab", "cache", "artifacts", "core-glibc-2.22-20170513201042-x86_64-linux.hart"),
+		manifest.Keys[0]:      keyPath,
+	}
+
+	bundle := filepath.Join(t.TempDir(), "tools.tar")
+	if err := writeBundle(bundle, manifest, files); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	dir := t.TempDir()
+	extracted, err := extractBundle(bundle, dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(extracted, manifest) {
+		t.Errorf("Expected manifest %v, actual %v", manifest, extracted)
+	}
+	key, err := ioutil.ReadFile(filepath.Join(dir, "keys", "core-20160810182414.pub"))
+	if err != nil || string(key) != "SIG-PUB-1" {
+		t.Errorf("Expected key to be extracted, actual %q (%v)", key, err)
+	}
+}
+
+func TestExtractBundleRejectsUnexpectedEntries(t *testing.T) {
+	tests := []struct {
+		name        string
+		expectError bool
+	}{
+		{"../escape", true},
+		{"a/../../escape", true},
+		{"..", true},
+		{"/etc/escape", true},
+		{"..foo", false},
+		{"a/../foo", false},
+	}
+
+	for _, test := range tests {
+		bundle := filepath.Join(t.TempDir(), "bundle.tar")
+		f, err := os.Create(bundle)
+		if err != nil {
+			t.Fatalf("Unable to create bundle: %v", err)
+		}
+		tw := tar.NewWriter(f)
+		tw.WriteHeader(&tar.Header{Name: test.name, Mode: 0644, Size: 1})
+		tw.Write([]byte("x"))
+		manifest := []byte("{}")
+		tw.WriteHeader(&tar.Header{Name: bundleManifestName, Mode: 0644, Size: int64(len(manifest))})
+		tw.Write(manifest)
+		tw.Close()
+		f.Close()
+
+		_, err = extractBundle(bundle, t.TempDir())
+		if test.expectError && err == nil {
+			t.Errorf("Expected error for the entry %q", test.name)
+		}
+		if !test.expectError && err != nil {
+			t.Errorf("Unexpected error for the entry %q: %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+// Package step resolves, installs and executes habitat packages for Screwdriver steps.
+// It is the library behind the sd-step command, so that other tools can embed its behavior.
+package step
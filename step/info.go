@@ -0,0 +1,281 @@
+package step
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/screwdriver-cd/sd-step/hab"
+)
+
+// Info is the metadata of a release of a package and whether it is installed.
+type Info struct {
+	Origin    string             `json:"origin"`
+	Name      string             `json:"name"`
+	Version   string             `json:"version"`
+	Release   string             `json:"release"`
+	Channels  []string           `json:"channels"`
+	Target    string             `json:"target"`
+	Checksum  string             `json:"checksum"`
+	BuildDate string             `json:"build_date"`
+	Deps      []hab.PackageIdent `json:"deps"`
+	TDeps     []hab.PackageIdent `json:"tdeps"`
+	// Installed tells if the release is installed, in Path whose files take Size bytes.
+	Installed bool   `json:"installed"`
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+}
+
+// Ident returns the identifier of the release.
+func (info Info) Ident() hab.PackageIdent {
+	return hab.PackageIdent{Origin: info.Origin, Name: info.Name, Version: info.Version, Release: info.Release}
+}
+
+// Inspector tells the metadata of packages.
+type Inspector interface {
+	// Inspect returns the metadata of the latest release of pkg in its channel, or of the
+	// release which pkg identifies.
+	Inspect(pkg Package) (Info, error)
+	// Search returns the packages whose origin/name contains term with their latest releases.
+	Search(term, origin, habChannels string) ([]Info, error)
+}
+
+// Locator finds installed packages.
+type Locator interface {
+	// Path returns the directory which pkg is installed in.
+	Path(pkg Package) (string, error)
+}
+
+// DepotInspector tells the metadata of packages from a depot and the packages installed in the
+// HabRoot of Config.
+type DepotInspector struct {
+	Config
+	// Depot tells the details of releases if it is a hab.MetadataDepot.
+	Depot hab.Depot
+	// Locator finds the packages which are not in HabRoot, such as the ones hab installed
+	// elsewhere. They are not looked up if it is nil.
+	Locator Locator
+}
+
+// NewInspector returns a new DepotInspector which finds the packages with locator if they are
+// not in cfg.HabRoot.
+func NewInspector(depot hab.Depot, locator Locator, cfg Config) *DepotInspector {
+	return &DepotInspector{
+		Config:  cfg,
+		Depot:   depot,
+		Locator: locator,
+	}
+}
+
+// Inspect returns the metadata of pkg from the depot with the metadata files of the installed
+// release. The installed release alone is described when the depot is unavailable.
+func (i *DepotInspector) Inspect(pkg Package) (Info, error) {
+	ident, err := pkg.Ident()
+	if err != nil {
+		return Info{}, err
+	}
+	ctx := i.context()
+
+	detail, depotErr := i.depotDetail(ctx, ident, pkg.Channel)
+	if depotErr != nil && ctx.Err() != nil {
+		return Info{}, context.Cause(ctx)
+	}
+
+	installed := ident
+	if depotErr == nil {
+		installed = detail.Ident
+	}
+	local, path, localErr := i.installed(ctx, installed)
+	if depotErr != nil {
+		if localErr != nil {
+			return Info{}, fmt.Errorf("unable to get the detail of %s: %w", ident, depotErr)
+		}
+		fmt.Fprintf(i.stderr(), "WARNING: Unable to get the detail of %s from the depot, "+
+			"describing the installed package instead: %v\n", ident, depotErr)
+		detail = local
+	}
+
+	info := infoOf(detail)
+	if localErr == nil {
+		info.Installed, info.Path = true, path
+		info.Size, _ = dirSize(path)
+		// the depot may leave out what the metadata files of the package tell
+		if info.Target == "" {
+			info.Target = local.Target
+		}
+		if len(info.Deps) == 0 {
+			info.Deps = local.Deps
+		}
+		if len(info.TDeps) == 0 {
+			info.TDeps = local.TDeps
+		}
+	}
+	return info, nil
+}
+
+// infoOf returns the Info of the release which detail describes.
+func infoOf(detail hab.PackageDetail) Info {
+	info := Info{
+		Origin:   detail.Ident.Origin,
+		Name:     detail.Ident.Name,
+		Version:  detail.Ident.Version,
+		Release:  detail.Ident.Release,
+		Channels: detail.Channels,
+		Target:   detail.Target,
+		Checksum: detail.Checksum,
+		Deps:     detail.Deps,
+		TDeps:    detail.TDeps,
+	}
+	if date, err := detail.BuildDate(); err == nil {
+		info.BuildDate = date.Format(time.RFC3339)
+	}
+	return info
+}
+
+// searchWorkers is the number of packages in search results whose latest releases are fetched
+// at the same time.
+const searchWorkers = 4
+
+// Search searches the depot for term and fetches the latest release of each package found.
+func (i *DepotInspector) Search(term, origin, habChannels string) ([]Info, error) {
+	searcher, ok := i.Depot.(hab.Searcher)
+	if !ok {
+		return nil, errors.New("the depot does not search packages")
+	}
+	depot, ok := i.Depot.(hab.MetadataDepot)
+	if !ok {
+		return nil, errors.New("the depot does not tell the details of packages")
+	}
+	ctx := i.context()
+
+	idents, err := searcher.Search(ctx, term)
+	if err != nil {
+		return nil, err
+	}
+	var found []hab.PackageIdent
+	for _, ident := range idents {
+		if origin == "" || ident.Origin == origin {
+			found = append(found, ident)
+		}
+	}
+
+	results := make([]Info, len(found))
+	errs := make([]error, len(found))
+	sem := make(chan struct{}, searchWorkers)
+	var wg sync.WaitGroup
+	for n, ident := range found {
+		wg.Add(1)
+		go func(n int, ident hab.PackageIdent) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results[n] = Info{Origin: ident.Origin, Name: ident.Name}
+			for _, channel := range splitChannels(habChannels) {
+				detail, err := depot.LatestInChannel(ctx, ident.String(), channel)
+				if errors.Is(err, hab.ErrPackageNotFound) {
+					continue
+				}
+				if err != nil {
+					errs[n] = err
+					return
+				}
+				results[n] = infoOf(detail)
+				return
+			}
+		}(n, ident)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return nil, context.Cause(ctx)
+	}
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// depotDetail returns the detail of the latest release of ident in habChannel, or in any channel
+// if habChannel is empty or ident is fully qualified.
+func (i *DepotInspector) depotDetail(ctx context.Context, ident hab.PackageIdent, habChannel string) (hab.PackageDetail, error) {
+	depot, ok := i.Depot.(hab.MetadataDepot)
+	if !ok {
+		return hab.PackageDetail{}, errors.New("the depot does not tell the details of packages")
+	}
+	if habChannel == "" || ident.FullyQualified() {
+		return depot.PackageDetail(ctx, ident.String())
+	}
+	return depot.LatestInChannel(ctx, ident.String(), habChannel)
+}
+
+// installed returns the metadata of the latest installed release matching ident and the
+// directory which it is installed in. The metadata is read only from the releases in HabRoot.
+func (i *DepotInspector) installed(ctx context.Context, ident hab.PackageIdent) (hab.PackageDetail, string, error) {
+	if fullIdent, err := installedIdent(filepath.Join("/", i.HabRoot), ident); err == nil {
+		detail, err := hab.NewDirDepot(i.pkgsDir()).PackageDetail(ctx, fullIdent.String())
+		if err != nil {
+			return hab.PackageDetail{}, "", err
+		}
+		return detail, filepath.Join(i.pkgsDir(), filepath.FromSlash(fullIdent.String())), nil
+	}
+	if i.Locator == nil {
+		return hab.PackageDetail{}, "", fmt.Errorf("%s is not installed", ident)
+	}
+
+	path, err := i.Locator.Path(Package{Name: ident.String()})
+	if err != nil {
+		return hab.PackageDetail{}, "", fmt.Errorf("%s is not installed", ident)
+	}
+	// the path of a release ends with its ident like /hab/pkgs/core/node/8.9.0/20171101000000
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	if len(parts) < 4 {
+		return hab.PackageDetail{}, "", fmt.Errorf("%s is not the path of a package", path)
+	}
+	fullIdent, err := hab.ParseIdent(strings.Join(parts[len(parts)-4:], "/"))
+	if err != nil || !fullIdent.FullyQualified() {
+		return hab.PackageDetail{}, "", fmt.Errorf("%s is not the path of a package", path)
+	}
+	return hab.PackageDetail{Ident: fullIdent}, path, nil
+}
+
+// dirSize returns the total size of the regular files under dir.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// Path returns the directory of the installed package which `hab pkg path` tells.
+func (h *Hab) Path(p Package) (string, error) {
+	ident, err := p.Ident()
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := phaseContext(h.context(), h.InstallTimeout)
+	defer cancel()
+
+	output := new(bytes.Buffer)
+	pathCmd := strings.Join(h.command("pkg", "path", ident.String()), " ") + " 2>/dev/null"
+	if err := h.Runner.Run(ctx, pathCmd, output); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(output.String()), nil
+}
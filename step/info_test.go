@@ -0,0 +1,170 @@
+package step
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/screwdriver-cd/sd-step/hab"
+	"github.com/screwdriver-cd/sd-step/hab/habtest"
+)
+
+// staticLocator finds every package in its directory.
+type staticLocator string
+
+func (l staticLocator) Path(pkg Package) (string, error) {
+	if l == "" {
+		return "", errors.New("not installed")
+	}
+	return string(l), nil
+}
+
+func TestInspect(t *testing.T) {
+	server := habtest.NewServer(
+		habtest.Package("foo/test/1.0.0/20170524100001", "stable"),
+		habtest.Package("foo/test/1.1.0/20170524100002", "stable"),
+		habtest.Package("foo/test/2.0.0/20170524100003", "unstable"),
+	)
+	defer server.Close()
+	glibc := hab.PackageIdent{Origin: "core", Name: "glibc", Version: "2.22", Release: "20170513215607"}
+	server.SetDetail(hab.PackageDetail{
+		Ident:    hab.PackageIdent{Origin: "foo", Name: "test", Version: "1.1.0", Release: "20170524100002"},
+		Checksum: "0123456789abcdef",
+		Deps:     []hab.PackageIdent{glibc},
+		TDeps:    []hab.PackageIdent{glibc},
+		Channels: []string{"stable"},
+	})
+
+	root := t.TempDir()
+	installed := filepath.Join(root, "hab", "pkgs", "foo", "test", "1.1.0", "20170524100002")
+	if err := os.MkdirAll(installed, 0755); err != nil {
+		t.Fatalf("Unable to create package directory: %v", err)
+	}
+	ioutil.WriteFile(filepath.Join(installed, "TARGET"), []byte("x86_64-linux\n"), 0644)
+	ioutil.WriteFile(filepath.Join(installed, "IDENT"), []byte("foo/test/1.1.0/20170524100002\n"), 0644)
+
+	cfg := DefaultConfig()
+	cfg.HabRoot = root
+	stderr := new(bytes.Buffer)
+	cfg.Stderr = stderr
+	i := NewInspector(hab.New(server.DepotURL()), nil, cfg)
+
+	info, err := i.Inspect(Package{"foo/test", "1.1.0", "stable"})
+	if err != nil {
+		t.Fatalf("Inspect error = %v, should be nil", err)
+	}
+	expected := Info{
+		Origin:    "foo",
+		Name:      "test",
+		Version:   "1.1.0",
+		Release:   "20170524100002",
+		Channels:  []string{"stable"},
+		Target:    "x86_64-linux",
+		Checksum:  "0123456789abcdef",
+		BuildDate: "2017-05-24T10:00:02Z",
+		Deps:      []hab.PackageIdent{glibc},
+		TDeps:     []hab.PackageIdent{glibc},
+		Installed: true,
+		Path:      installed,
+		Size:      int64(len("x86_64-linux\n") + len("foo/test/1.1.0/20170524100002\n")),
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Errorf("Expected %+v, actual %+v", expected, info)
+	}
+
+	info, err = i.Inspect(Package{"foo/test", "2.0.0", "unstable"})
+	if err != nil {
+		t.Fatalf("Inspect error = %v, should be nil", err)
+	}
+	if info.Ident().String() != "foo/test/2.0.0/20170524100003" || info.Installed || info.Path != "" {
+		t.Errorf("Expected foo/test/2.0.0 which is not installed, actual %+v", info)
+	}
+
+	// the locator finds the packages which are not in the habitat root
+	i.Locator = staticLocator("/opt/hab/pkgs/foo/test/1.0.0/20170524100001")
+	info, err = i.Inspect(Package{"foo/test/1.0.0/20170524100001", "", ""})
+	if err != nil {
+		t.Fatalf("Inspect error = %v, should be nil", err)
+	}
+	if !info.Installed || info.Path != "/opt/hab/pkgs/foo/test/1.0.0/20170524100001" {
+		t.Errorf("Expected foo/test/1.0.0 to be installed by the locator, actual %+v", info)
+	}
+
+	// the installed package is described when the depot is unavailable
+	server.Inject(habtest.Fault{StatusCode: http.StatusServiceUnavailable}, habtest.Fault{StatusCode: http.StatusServiceUnavailable})
+	info, err = i.Inspect(Package{"foo/test", "1.1.0", "stable"})
+	if err != nil {
+		t.Fatalf("Inspect error = %v, should be nil", err)
+	}
+	if info.Ident().String() != "foo/test/1.1.0/20170524100002" || info.Target != "x86_64-linux" || !info.Installed {
+		t.Errorf("Expected the installed foo/test/1.1.0, actual %+v", info)
+	}
+	if !strings.Contains(stderr.String(), "WARNING: Unable to get the detail of foo/test/1.1.0 from the depot") {
+		t.Errorf("Expected the depot failure to be reported, actual %q", stderr.String())
+	}
+
+	i.Locator = nil
+	_, err = i.Inspect(Package{"foo/test", "2.0.0", "unstable"})
+	if !errors.Is(err, hab.ErrDepotUnavailable) {
+		t.Errorf("Expected ErrDepotUnavailable, actual %v", err)
+	}
+	_, err = i.Inspect(Package{"foo/missing", "1.0.0", "stable"})
+	if !errors.Is(err, hab.ErrPackageNotFound) {
+		t.Errorf("Expected ErrPackageNotFound, actual %v", err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	server := habtest.NewServer(
+		habtest.Package("core/node/8.9.0/20171101000000", "stable"),
+		habtest.Package("core/node/9.0.0/20171201000000", "unstable"),
+		habtest.Package("core/nodejs-tools/1.0.0/20180301000000", "unstable"),
+		habtest.Package("foo/node-app/0.1.0/20180301000000", "stable"),
+	)
+	defer server.Close()
+	i := NewInspector(hab.New(server.DepotURL()), nil, DefaultConfig())
+
+	tests := []struct {
+		origin   string
+		channels string
+		expected []string
+	}{
+		{"", "stable", []string{"core/node/8.9.0/20171101000000", "core/nodejs-tools", "foo/node-app/0.1.0/20180301000000"}},
+		{"core", "unstable,stable", []string{"core/node/9.0.0/20171201000000", "core/nodejs-tools/1.0.0/20180301000000"}},
+		{"bar", "stable", nil},
+	}
+
+	for _, test := range tests {
+		results, err := i.Search("node", test.origin, test.channels)
+		if err != nil {
+			t.Errorf("Search in %q of %q error = %v, should be nil", test.channels, test.origin, err)
+			continue
+		}
+		var idents []string
+		for _, result := range results {
+			idents = append(idents, result.Ident().String())
+		}
+		if !reflect.DeepEqual(idents, test.expected) {
+			t.Errorf("Search in %q of %q = %v, expected %v", test.channels, test.origin, idents, test.expected)
+		}
+	}
+
+	server.Inject(habtest.Fault{StatusCode: http.StatusServiceUnavailable})
+	if _, err := i.Search("node", "", "stable"); !errors.Is(err, hab.ErrDepotUnavailable) {
+		t.Errorf("Expected ErrDepotUnavailable, actual %v", err)
+	}
+}
+
+func TestInfoVersionTwice(t *testing.T) {
+	s := &Step{Resolver: staticResolver{}}
+
+	if _, err := s.Info("foo/test/1.0.0", "^1.0.0", "stable"); err == nil || !strings.Contains(err.Error(), "already specifies the version") {
+		t.Errorf("Expected the version to be rejected, actual %v", err)
+	}
+}
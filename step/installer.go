@@ -0,0 +1,261 @@
+package step
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// privilegeCommands are the command prefixes used to install packages for each privilege.
+var privilegeCommands = map[string][]string{
+	"sudo":   {"sudo"},
+	"sudo-n": {"sudo", "-n"},
+	"doas":   {"doas"},
+	"none":   nil,
+}
+
+// Hab installs and executes packages with the hab command.
+type Hab struct {
+	Config
+	Runner Runner
+
+	lookPath    func(file string) (string, error)
+	currentUser func() (*user.User, error)
+}
+
+// NewHab returns a new Hab which runs hab commands with runner.
+func NewHab(cfg Config, runner Runner) *Hab {
+	return &Hab{
+		Config:      cfg,
+		Runner:      runner,
+		lookPath:    exec.LookPath,
+		currentUser: user.Current,
+	}
+}
+
+// habEnv returns the environment variables which make hab use root as the filesystem root.
+func habEnv(root string) []string {
+	if root == "" {
+		return nil
+	}
+	return []string{
+		"FS_ROOT=" + root,
+		"HAB_CACHE_KEY_PATH=" + filepath.Join(root, "hab", "cache", "keys"),
+	}
+}
+
+// command returns the hab command line with args which runs in HabRoot.
+func (h *Hab) command(args ...string) []string {
+	return h.commandIn(h.HabRoot, args...)
+}
+
+// commandIn returns the hab command line with args which runs in the filesystem root.
+func (h *Hab) commandIn(root string, args ...string) []string {
+	return h.commandWithEnv(habEnv(root), args...)
+}
+
+// installCommand returns the hab pkg install command line for pkg from channel which installs
+// the release built for Target into the filesystem root.
+func (h *Hab) installCommand(root, pkg, channel string) []string {
+	env := habEnv(root)
+	if h.Target != "" {
+		env = append(env, "HAB_TARGET="+h.Target)
+	}
+	return h.commandWithEnv(env, "pkg", "install", pkg, "-c", channel, ">/dev/null")
+}
+
+// commandWithEnv returns the hab command line with args which runs with the environment variables.
+func (h *Hab) commandWithEnv(env []string, args ...string) []string {
+	var command []string
+	if len(env) > 0 {
+		// env is used instead of the environment of sh so that it survives privilege escalation
+		command = append(command, "env")
+		for _, e := range env {
+			command = append(command, shellQuote(e))
+		}
+	}
+	return append(append(command, h.HabPath), args...)
+}
+
+// execCommand returns the hab pkg exec command line for pkg, bound to /hab by proot if it is available.
+func (h *Hab) execCommand(pkg string) []string {
+	if h.HabRoot == "" {
+		return h.command("pkg", "exec", pkg)
+	}
+	if proot, err := h.lookPath("proot"); err == nil {
+		bind := shellQuote(filepath.Join(h.HabRoot, "hab") + ":/hab")
+		return []string{proot, "-b", bind, h.HabPath, "pkg", "exec", pkg}
+	}
+	fmt.Fprintf(h.stderr(), "WARNING: proot is not found, binaries installed in %s may not find "+
+		"their interpreters and libraries under /hab\n", h.HabRoot)
+	return h.command("pkg", "exec", pkg)
+}
+
+// isWritable checks if the user can write into dir or, if it does not exist yet, can create it.
+func isWritable(dir string) bool {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return syscall.Access(dir, 2) == nil // W_OK
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
+}
+
+// autoPrivilege selects the privilege to install packages with for the current user.
+func (h *Hab) autoPrivilege() string {
+	if u, err := h.currentUser(); err == nil && u.Uid == "0" {
+		return "none"
+	}
+	// hab installs into FS_ROOT, which needs no escalation if the user owns it
+	if h.HabRoot != "" && isWritable(h.HabRoot) {
+		return "none"
+	}
+	if _, err := h.lookPath("sudo"); err != nil {
+		if _, err := h.lookPath("doas"); err == nil {
+			return "doas"
+		}
+	}
+	return "sudo"
+}
+
+// privilegePrefix returns the command prefix to install packages with the Privilege.
+func (h *Hab) privilegePrefix() ([]string, error) {
+	mode := h.Privilege
+	if mode == "auto" || mode == "" {
+		mode = h.autoPrivilege()
+	}
+
+	prefix, ok := privilegeCommands[mode]
+	if !ok {
+		return nil, fmt.Errorf("%v is invalid privilege, it must be one of auto, sudo, sudo-n, doas or none", mode)
+	}
+
+	if len(prefix) > 0 {
+		if _, err := h.lookPath(prefix[0]); err != nil {
+			return nil, fmt.Errorf("%s is required to install packages as non-root user but it is not found, "+
+				"use --privilege to change it or --hab-root to install into a writable directory", prefix[0])
+		}
+	}
+
+	return append([]string(nil), prefix...), nil
+}
+
+// IsInstalled checks if the package is installed.
+func (h *Hab) IsInstalled(p Package) bool {
+	var output io.Writer
+
+	ident, err := p.Ident()
+	if err != nil {
+		return false
+	}
+
+	ctx, cancel := phaseContext(h.context(), h.InstallTimeout)
+	defer cancel()
+
+	// hab pkg path command exits with zero if pkg exists
+	checkCmd := strings.Join(h.command("pkg", "path", ident.String()), " ") + " >/dev/null 2>&1"
+	checkCmdResult := h.Runner.Run(ctx, checkCmd, output)
+
+	return checkCmdResult == nil
+}
+
+// Install installs habitat package if it is not installed yet.
+func (h *Hab) Install(p Package, output io.Writer) error {
+	ident, verErr := p.Ident()
+	if verErr != nil {
+		return verErr
+	}
+
+	if h.IsInstalled(p) {
+		return nil
+	}
+
+	prefix, privErr := h.privilegePrefix()
+	if privErr != nil {
+		return privErr
+	}
+
+	installCmd := append(prefix, h.installCommand(h.HabRoot, ident.String(), p.Channel)...)
+
+	ctx, cancel := phaseContext(h.context(), h.InstallTimeout)
+	defer cancel()
+
+	unwrappedInstallCommand := strings.Join(installCmd, " ")
+	installErr := h.Runner.Run(ctx, unwrappedInstallCommand, output)
+	return phaseError("install", h.InstallTimeout, installErr)
+}
+
+// Exec executes habitat command with the installed package.
+func (h *Hab) Exec(p Package, command []string, output io.Writer) error {
+	return h.ExecWithStderr(p, command, output, nil)
+}
+
+// ExecWithStderr executes command like Exec and writes its error output to stderr. The error
+// output goes where the Runner writes it if stderr is nil or the Runner is not a StderrRunner.
+func (h *Hab) ExecWithStderr(p Package, command []string, output, stderr io.Writer) error {
+	ident, verErr := p.Ident()
+	if verErr != nil {
+		return verErr
+	}
+
+	ctx, cancel := phaseContext(h.context(), h.ExecTimeout)
+	defer cancel()
+
+	execCmd := h.execCommand(ident.String())
+	unwrappedExecCommand := strings.Join(append(execCmd, command...), " ")
+	var execErr error
+	if runner, ok := h.Runner.(StderrRunner); ok && stderr != nil {
+		execErr = runner.RunWithStderr(ctx, unwrappedExecCommand, output, stderr)
+	} else {
+		execErr = h.Runner.Run(ctx, unwrappedExecCommand, output)
+	}
+	if execErr != nil {
+		return phaseError("exec", h.ExecTimeout, execErr)
+	}
+
+	return nil
+}
+
+// Shell launches an interactive shell with the runtime environment of the installed pkgs.
+func (h *Hab) Shell(pkgs []Package) error {
+	var names []string
+	var command []string
+
+	for _, p := range pkgs {
+		ident, verErr := p.Ident()
+		if verErr != nil {
+			return verErr
+		}
+		pkg := ident.String()
+
+		// nest hab pkg exec so that each package adds its environment on top of the previous one
+		if len(command) == 0 {
+			command = h.execCommand(pkg)
+		} else {
+			command = append(command, h.HabPath, "pkg", "exec", pkg)
+		}
+		names = append(names, p.Name)
+	}
+
+	active := strings.Join(names, " ")
+	command = append(command, "env",
+		shellQuote("SD_STEP_PACKAGES="+active),
+		shellQuote(`PS1=(sd-step: `+active+`) \$ `),
+		`"${SHELL:-/bin/sh}"`)
+
+	return h.Runner.RunInteractive(strings.Join(command, " "))
+}
+
+// shellQuote quotes s for use as a single word in sh.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,267 @@
+package step
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"os/user"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const habExecResult = "run hab pkg install\nrun hab pkg exec\n"
+
+// staticResolver resolves every package to its version expression.
+type staticResolver struct{}
+
+func (staticResolver) Resolve(pkgName, pkgVerExp, habChannels string) (Package, error) {
+	return Package{pkgName, pkgVerExp, habChannels}, nil
+}
+
+func (r staticResolver) ResolveAll(pkgName, pkgVerExp, habChannels string) ([]Package, error) {
+	pkg, err := r.Resolve(pkgName, pkgVerExp, habChannels)
+	return []Package{pkg}, err
+}
+
+// fakeHab returns a Hab which runs the helper process and finds commands in `commands`.
+func fakeHab(cfg Config, commands ...string) *Hab {
+	h := NewHab(cfg, fakeRunner())
+	h.lookPath = func(file string) (string, error) {
+		for _, command := range commands {
+			if command == file {
+				return "/usr/bin/" + file, nil
+			}
+		}
+		return "", exec.ErrNotFound
+	}
+	return h
+}
+
+func TestExecHab(t *testing.T) {
+	stdout := new(bytes.Buffer)
+	h := fakeHab(DefaultConfig(), "sudo")
+	s := &Step{Resolver: staticResolver{}, Installer: h, Executor: h}
+
+	err := s.Exec("foo/bar", "2.2.2", "stable", []string{"foo", "bar", "foobar"}, stdout)
+	if err != nil {
+		t.Errorf("Exec error = %q, should be nil", err)
+	}
+	if s := stdout.String(); s != habExecResult {
+		t.Errorf("Expected %q, got %q", habExecResult, s)
+	}
+}
+
+func TestExecHabTimeout(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ExecTimeout = 100 * time.Millisecond
+	h := fakeHab(cfg, "sudo")
+
+	stdout := new(bytes.Buffer)
+	err := h.Exec(Package{"foo/bar", "2.2.2", "stable"}, []string{"sleep"}, stdout)
+
+	var timedOut *TimeoutError
+	if !errors.As(err, &timedOut) {
+		t.Fatalf("Expected TimeoutError, actual %v", err)
+	}
+	if timedOut.Phase != "exec" {
+		t.Errorf("Expected exec phase to time out, actual %s", timedOut.Phase)
+	}
+}
+
+func TestPrivilegePrefix(t *testing.T) {
+	writableRoot := t.TempDir()
+
+	tests := []struct {
+		privilege      string
+		uid            string
+		habRoot        string
+		commands       []string
+		expectedPrefix []string
+		expectedError  bool
+	}{
+		{"auto", "0", "", []string{"sudo"}, nil, false},
+		{"auto", "1000", "", []string{"sudo"}, []string{"sudo"}, false},
+		{"auto", "1000", "", []string{"doas"}, []string{"doas"}, false},
+		{"auto", "1000", "", nil, nil, true},
+		{"auto", "1000", writableRoot, nil, nil, false},
+		{"sudo", "0", "", []string{"sudo"}, []string{"sudo"}, false},
+		{"sudo-n", "1000", "", []string{"sudo"}, []string{"sudo", "-n"}, false},
+		{"sudo", "1000", "", []string{"doas"}, nil, true},
+		{"doas", "1000", "", []string{"doas"}, []string{"doas"}, false},
+		{"none", "1000", "", nil, nil, false},
+		{"su", "1000", "", []string{"su"}, nil, true},
+	}
+
+	for _, test := range tests {
+		cfg := DefaultConfig()
+		cfg.Privilege = test.privilege
+		cfg.HabRoot = test.habRoot
+		h := fakeHab(cfg, test.commands...)
+		uid := test.uid
+		h.currentUser = func() (*user.User, error) {
+			return &user.User{Uid: uid}, nil
+		}
+
+		prefix, err := h.privilegePrefix()
+		if test.expectedError {
+			if err == nil {
+				t.Errorf("Expected error for privilege %s as uid %s, actual nil", test.privilege, test.uid)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(prefix, test.expectedPrefix) {
+			t.Errorf("Expected prefix %v for privilege %s as uid %s, actual %v", test.expectedPrefix, test.privilege, test.uid, prefix)
+		}
+	}
+}
+
+func TestHabExecCommand(t *testing.T) {
+	tests := []struct {
+		habRoot  string
+		commands []string
+		expected []string
+	}{
+		{"", []string{"proot"}, []string{DefaultHabPath, "pkg", "exec", "foo/bar"}},
+		{"/home/sd/.sd-step/hab", []string{"proot"},
+			[]string{"/usr/bin/proot", "-b", "'/home/sd/.sd-step/hab/hab:/hab'", DefaultHabPath, "pkg", "exec", "foo/bar"}},
+		{"/home/sd/.sd-step/hab", nil,
+			[]string{"env", "'FS_ROOT=/home/sd/.sd-step/hab'", "'HAB_CACHE_KEY_PATH=/home/sd/.sd-step/hab/hab/cache/keys'",
+				DefaultHabPath, "pkg", "exec", "foo/bar"}},
+	}
+
+	for _, test := range tests {
+		cfg := DefaultConfig()
+		cfg.HabRoot = test.habRoot
+		cfg.Stderr = new(bytes.Buffer)
+		h := fakeHab(cfg, test.commands...)
+
+		if command := h.execCommand("foo/bar"); !reflect.DeepEqual(command, test.expected) {
+			t.Errorf("Expected %q with hab root %q, actual %q", test.expected, test.habRoot, command)
+		}
+	}
+}
+
+func TestHabInstallCommand(t *testing.T) {
+	tests := []struct {
+		habRoot  string
+		target   string
+		expected []string
+	}{
+		{"", "", []string{DefaultHabPath, "pkg", "install", "foo/bar/1.0.0", "-c", "stable", ">/dev/null"}},
+		{"", "aarch64-linux",
+			[]string{"env", "'HAB_TARGET=aarch64-linux'", DefaultHabPath, "pkg", "install", "foo/bar/1.0.0", "-c", "stable", ">/dev/null"}},
+		{"/home/sd/.sd-step/hab", "x86_64-linux",
+			[]string{"env", "'FS_ROOT=/home/sd/.sd-step/hab'", "'HAB_CACHE_KEY_PATH=/home/sd/.sd-step/hab/hab/cache/keys'",
+				"'HAB_TARGET=x86_64-linux'", DefaultHabPath, "pkg", "install", "foo/bar/1.0.0", "-c", "stable", ">/dev/null"}},
+	}
+
+	for _, test := range tests {
+		cfg := DefaultConfig()
+		cfg.Target = test.target
+		h := fakeHab(cfg)
+
+		if command := h.installCommand(test.habRoot, "foo/bar/1.0.0", "stable"); !reflect.DeepEqual(command, test.expected) {
+			t.Errorf("Expected %q on %q, actual %q", test.expected, test.target, command)
+		}
+	}
+}
+
+// recordingRunner records the command lines which it runs instead of running them.
+type recordingRunner struct {
+	commands []string
+}
+
+func (r *recordingRunner) Run(ctx context.Context, command string, output io.Writer) error {
+	r.commands = append(r.commands, command)
+	return nil
+}
+
+func (r *recordingRunner) RunInteractive(command string) error {
+	r.commands = append(r.commands, command)
+	return nil
+}
+
+func TestHabShell(t *testing.T) {
+	shellArgs := func(active string) string {
+		return ` env 'SD_STEP_PACKAGES=` + active + `' 'PS1=(sd-step: ` + active + `) \$ ' "${SHELL:-/bin/sh}"`
+	}
+
+	tests := []struct {
+		habRoot  string
+		commands []string
+		pkgs     []Package
+		expected string
+	}{
+		{"", nil, []Package{{"foo/bar", "1.0.0", "stable"}},
+			DefaultHabPath + " pkg exec foo/bar/1.0.0" + shellArgs("foo/bar")},
+		{"", nil, []Package{{"foo/bar", "1.0.0", "stable"}, {"foo/baz", "", "stable"}},
+			DefaultHabPath + " pkg exec foo/bar/1.0.0 " + DefaultHabPath + " pkg exec foo/baz" + shellArgs("foo/bar foo/baz")},
+		{"/home/sd/.sd-step/hab", []string{"proot"}, []Package{{"foo/bar", "1.0.0", "stable"}, {"foo/baz", "", "stable"}},
+			"/usr/bin/proot -b '/home/sd/.sd-step/hab/hab:/hab' " + DefaultHabPath + " pkg exec foo/bar/1.0.0 " +
+				DefaultHabPath + " pkg exec foo/baz" + shellArgs("foo/bar foo/baz")},
+		{"/home/sd/.sd-step/hab", nil, []Package{{"foo/bar", "1.0.0", "stable"}},
+			"env 'FS_ROOT=/home/sd/.sd-step/hab' 'HAB_CACHE_KEY_PATH=/home/sd/.sd-step/hab/hab/cache/keys' " +
+				DefaultHabPath + " pkg exec foo/bar/1.0.0" + shellArgs("foo/bar")},
+	}
+
+	for _, test := range tests {
+		cfg := DefaultConfig()
+		cfg.HabRoot = test.habRoot
+		cfg.Stderr = new(bytes.Buffer)
+		h := fakeHab(cfg, test.commands...)
+		runner := &recordingRunner{}
+		h.Runner = runner
+
+		if err := h.Shell(test.pkgs); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(runner.commands, []string{test.expected}) {
+			t.Errorf("Expected %q with hab root %q, actual %q", test.expected, test.habRoot, runner.commands)
+		}
+	}
+}
+
+func TestStepShellInstallOutput(t *testing.T) {
+	stderr := new(bytes.Buffer)
+	h := fakeHab(DefaultConfig(), "sudo")
+	h.Runner = fakeRunner()
+	s := &Step{Resolver: staticResolver{}, Installer: h, Executor: fakeShellExecutor{}, Stderr: stderr}
+
+	if err := s.Shell([]string{"foo/bar@2.2.2"}, "stable"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stderr.String() != "run hab pkg install\n" {
+		t.Errorf("Expected the output of install in Stderr, actual %q", stderr.String())
+	}
+}
+
+// fakeShellExecutor executes nothing and starts no shell.
+type fakeShellExecutor struct{}
+
+func (fakeShellExecutor) Exec(pkg Package, command []string, output io.Writer) error {
+	return nil
+}
+
+func (fakeShellExecutor) Shell(pkgs []Package) error {
+	return nil
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := map[string]string{
+		"foo":         "'foo'",
+		"PS1=(a b) $": "'PS1=(a b) $'",
+		"it's":        `'it'\''s'`,
+	}
+
+	for input, expected := range tests {
+		if actual := shellQuote(input); actual != expected {
+			t.Errorf("shellQuote(%q) = %q, expected %q", input, actual, expected)
+		}
+	}
+}
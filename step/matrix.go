@@ -0,0 +1,156 @@
+package step
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/screwdriver-cd/sd-step/hab"
+)
+
+// selectionParts is the number of leading version parts which make a group of versions for
+// each selection, where the highest version of each group is selected. Zero selects all.
+var selectionParts = map[string]int{
+	"all":          0,
+	"latest-major": 1,
+	"latest-minor": 2,
+}
+
+// MatrixResult is the result of running a command with one version of a package.
+type MatrixResult struct {
+	Package  Package
+	Err      error
+	Duration time.Duration
+}
+
+// Passed checks if the package was installed and the command succeeded.
+func (r MatrixResult) Passed() bool {
+	return r.Err == nil
+}
+
+// SelectVersions selects all of pkgs, or the highest version of each major or minor version.
+func SelectVersions(pkgs []Package, selection string) ([]Package, error) {
+	n, ok := selectionParts[selection]
+	if !ok {
+		return nil, fmt.Errorf("%v is invalid selection, it must be one of all, latest-major or latest-minor", selection)
+	}
+	if n == 0 {
+		return pkgs, nil
+	}
+
+	var selected []Package
+	groups := map[string]int{}
+	for _, pkg := range pkgs {
+		parts, err := hab.VersionParts(pkg.Version)
+		if err != nil {
+			selected = append(selected, pkg)
+			continue
+		}
+		// missing parts count as 0, so 9 is in the same minor version as 9.0.1
+		for len(parts) < n {
+			parts = append(parts, 0)
+		}
+
+		group := fmt.Sprint(parts[:n])
+		if i, ok := groups[group]; ok {
+			selected[i] = pkg
+		} else {
+			groups[group] = len(selected)
+			selected = append(selected, pkg)
+		}
+	}
+	return selected, nil
+}
+
+// Matrix executes command with each selected version matching `pkgVerExp`, `parallel` at a time.
+func (s *Step) Matrix(pkgName, pkgVerExp, habChannels, selection string, parallel int, command []string, output io.Writer) ([]MatrixResult, error) {
+	pkgs, err := s.Resolver.ResolveAll(pkgName, pkgVerExp, habChannels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get package versions: %w", err)
+	}
+	pkgs, err = SelectVersions(pkgs, selection)
+	if err != nil {
+		return nil, err
+	}
+	ctx := s.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// packages are installed one by one before any command runs
+	results := make([]MatrixResult, len(pkgs))
+	for i, pkg := range pkgs {
+		results[i].Package = pkg
+		if ctx.Err() != nil {
+			results[i].Err = context.Cause(ctx)
+			continue
+		}
+		if err := s.Installer.Install(pkg, output); err != nil {
+			results[i].Err = fmt.Errorf("failed to install: %w", err)
+		}
+	}
+
+	if parallel < 1 {
+		parallel = 1
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan int)
+	for w := 0; w < parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				result := &results[i]
+				ident, _ := result.Package.Ident()
+
+				if parallel == 1 {
+					fmt.Fprintf(output, "==> %s\n", ident)
+					start := time.Now()
+					result.Err = s.Executor.Exec(result.Package, command, output)
+					result.Duration = time.Since(start)
+					continue
+				}
+
+				// the output of parallel commands is buffered so that it does not interleave
+				var buf bytes.Buffer
+				start := time.Now()
+				if executor, ok := s.Executor.(StderrExecutor); ok {
+					result.Err = executor.ExecWithStderr(result.Package, command, &buf, &buf)
+				} else {
+					result.Err = s.Executor.Exec(result.Package, command, &buf)
+				}
+				result.Duration = time.Since(start)
+
+				mu.Lock()
+				fmt.Fprintf(output, "==> %s\n", ident)
+				buf.WriteTo(output)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := range results {
+		if results[i].Err != nil {
+			continue
+		}
+		if ctx.Err() == nil {
+			select {
+			case jobs <- i:
+				continue
+			case <-ctx.Done():
+			}
+		}
+		results[i].Err = context.Cause(ctx)
+	}
+	close(jobs)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return results, context.Cause(ctx)
+	}
+	return results, nil
+}
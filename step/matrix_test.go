@@ -0,0 +1,175 @@
+package step
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSelectVersions(t *testing.T) {
+	var pkgs []Package
+	for _, version := range []string{"8", "8.0.1", "8.1.0", "8.1.2", "9.0.0", "9.5", "2017.11.1", "2017.11.12", "master"} {
+		pkgs = append(pkgs, Package{"foo/test", version, "stable"})
+	}
+
+	tests := []struct {
+		selection   string
+		expected    []string
+		expectError bool
+	}{
+		{"all", []string{"8", "8.0.1", "8.1.0", "8.1.2", "9.0.0", "9.5", "2017.11.1", "2017.11.12", "master"}, false},
+		{"latest-major", []string{"8.1.2", "9.5", "2017.11.12", "master"}, false},
+		{"latest-minor", []string{"8.0.1", "8.1.2", "9.0.0", "9.5", "2017.11.12", "master"}, false},
+		{"latest-patch", nil, true},
+	}
+
+	for _, test := range tests {
+		selected, err := SelectVersions(pkgs, test.selection)
+		if test.expectError {
+			if err == nil {
+				t.Errorf("Expected error for %q, actual nil", test.selection)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", test.selection, err)
+		}
+
+		var versions []string
+		for _, pkg := range selected {
+			versions = append(versions, pkg.Version)
+		}
+		if !reflect.DeepEqual(versions, test.expected) {
+			t.Errorf("Expected %v for %q, actual %v", test.expected, test.selection, versions)
+		}
+	}
+}
+
+// versionsResolver resolves every package to each of its versions.
+type versionsResolver []string
+
+func (r versionsResolver) Resolve(pkgName, pkgVerExp, habChannels string) (Package, error) {
+	return Package{pkgName, r[len(r)-1], habChannels}, nil
+}
+
+func (r versionsResolver) ResolveAll(pkgName, pkgVerExp, habChannels string) ([]Package, error) {
+	var pkgs []Package
+	for _, version := range r {
+		pkgs = append(pkgs, Package{pkgName, version, habChannels})
+	}
+	return pkgs, nil
+}
+
+// matrixHab fails to install the versions in installErrs, writes the version to the output and
+// the error output of commands, and calls exec before each of them returns.
+type matrixHab struct {
+	installErrs map[string]error
+	exec        func(pkg Package) error
+}
+
+func (h *matrixHab) IsInstalled(pkg Package) bool {
+	return false
+}
+
+func (h *matrixHab) Install(pkg Package, output io.Writer) error {
+	return h.installErrs[pkg.Version]
+}
+
+func (h *matrixHab) Exec(pkg Package, command []string, output io.Writer) error {
+	return h.ExecWithStderr(pkg, command, output, ioutil.Discard)
+}
+
+func (h *matrixHab) ExecWithStderr(pkg Package, command []string, output, stderr io.Writer) error {
+	fmt.Fprintf(output, "stdout of %s\n", pkg.Version)
+	fmt.Fprintf(stderr, "stderr of %s\n", pkg.Version)
+	if h.exec == nil {
+		return nil
+	}
+	return h.exec(pkg)
+}
+
+func (h *matrixHab) Shell(pkgs []Package) error {
+	return nil
+}
+
+func TestMatrixParallelOutput(t *testing.T) {
+	installErr := errors.New("install error")
+	h := &matrixHab{installErrs: map[string]error{"1.1.0": installErr}}
+	s := &Step{Resolver: versionsResolver{"1.0.0", "1.1.0", "1.2.0"}, Installer: h, Executor: h}
+
+	output := new(bytes.Buffer)
+	results, err := s.Matrix("foo/test", "", "stable", "all", 2, []string{"test"}, output)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !errors.Is(results[1].Err, installErr) {
+		t.Errorf("Expected the install error of 1.1.0, actual %v", results[1].Err)
+	}
+	for _, version := range []string{"1.0.0", "1.2.0"} {
+		expected := fmt.Sprintf("==> foo/test/%s\nstdout of %s\nstderr of %s\n", version, version, version)
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("Expected %q in the output, actual %q", expected, output.String())
+		}
+	}
+}
+
+func TestMatrixTimeout(t *testing.T) {
+	h := &matrixHab{exec: func(pkg Package) error {
+		if pkg.Version == "1.1.0" {
+			return &TimeoutError{Phase: "exec", Timeout: time.Second}
+		}
+		return nil
+	}}
+	s := &Step{Resolver: versionsResolver{"1.0.0", "1.1.0", "1.2.0"}, Installer: h, Executor: h}
+
+	// a version which timed out fails like any other
+	results, err := s.Matrix("foo/test", "", "stable", "all", 1, []string{"test"}, ioutil.Discard)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, result := range results {
+		if result.Passed() != (result.Package.Version != "1.1.0") {
+			t.Errorf("Expected only 1.1.0 to fail, actual %v for %s", result.Err, result.Package.Version)
+		}
+	}
+}
+
+func TestMatrixInterrupted(t *testing.T) {
+	for _, parallel := range []int{1, 2} {
+		ctx, cancel := context.WithCancelCause(context.Background())
+		interrupted := &InterruptError{Signal: syscall.SIGINT}
+		h := &matrixHab{exec: func(pkg Package) error {
+			// the first command is interrupted, and the others are not started
+			cancel(interrupted)
+			return interrupted
+		}}
+		s := &Step{
+			Resolver:  versionsResolver{"1.0.0", "1.1.0", "1.2.0", "1.3.0", "1.4.0"},
+			Installer: h,
+			Executor:  h,
+			Context:   ctx,
+		}
+
+		results, err := s.Matrix("foo/test", "", "stable", "all", parallel, []string{"test"}, ioutil.Discard)
+		var interruptErr *InterruptError
+		if !errors.As(err, &interruptErr) || interruptErr.Signal != syscall.SIGINT {
+			t.Errorf("Expected the InterruptError with %d parallel, actual %v", parallel, err)
+		}
+		if len(results) != 5 {
+			t.Fatalf("Expected 5 results with %d parallel, actual %d", parallel, len(results))
+		}
+		for _, result := range results {
+			if !errors.As(result.Err, &interruptErr) {
+				t.Errorf("Expected %s to be interrupted with %d parallel, actual %v", result.Package.Version, parallel, result.Err)
+			}
+		}
+	}
+}
@@ -0,0 +1,454 @@
+package step
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Masterminds/semver"
+	"github.com/screwdriver-cd/sd-step/hab"
+)
+
+// ErrNoMatchingVersion is matched by the errors of resolution which found the package but none of
+// its versions matched the expression.
+var ErrNoMatchingVersion = errors.New("the specified version not found")
+
+// DepotResolver resolves versions from a depot with the AllowPrerelease, Strategy, Verbose and
+// Explain of Config.
+type DepotResolver struct {
+	Config
+	// Depot lists the versions of packages.
+	Depot hab.Depot
+	// FallbackDepot lists the versions when Depot is unreachable, nothing if it is nil.
+	FallbackDepot hab.Depot
+	// FallbackSource describes FallbackDepot in messages, "installed packages" if it is empty.
+	FallbackSource string
+	// Installer tells if an exact version is already installed, so that the depot is not needed.
+	Installer Installer
+}
+
+// NewResolver returns a new DepotResolver which falls back to the source of cfg.Fallback.
+func NewResolver(depot hab.Depot, installer Installer, cfg Config) *DepotResolver {
+	r := &DepotResolver{
+		Config:    cfg,
+		Depot:     depot,
+		Installer: installer,
+	}
+
+	switch cfg.Fallback {
+	case "never":
+	case "cache":
+		r.Depot = hab.NewCachingDepot(depot, cfg.cacheDir(), hab.WithTarget(cfg.Target))
+		r.FallbackDepot = hab.NewCacheDepot(cfg.cacheDir(), hab.WithTarget(cfg.Target))
+		r.FallbackSource = "cached depot versions"
+	default:
+		r.FallbackDepot = hab.NewDirDepot(cfg.pkgsDir(), hab.WithTarget(cfg.Target))
+	}
+	return r
+}
+
+// fallbackSource returns the description of FallbackDepot.
+func (r *DepotResolver) fallbackSource() string {
+	if r.FallbackSource == "" {
+		return "installed packages"
+	}
+	return r.FallbackSource
+}
+
+// strategies sort matching versions so that the one to choose comes first.
+var strategies = map[string]func(a, b versionReleases) bool{
+	"highest": func(a, b versionReleases) bool {
+		return hab.CompareVersions(a.version, b.version) > 0
+	},
+	"lowest": func(a, b versionReleases) bool {
+		return hab.CompareVersions(a.version, b.version) < 0
+	},
+	"newest": func(a, b versionReleases) bool {
+		// the identifiers without versions compare only the releases
+		if c := (hab.PackageIdent{Release: a.latestRelease}).Compare(hab.PackageIdent{Release: b.latestRelease}); c != 0 {
+			return c > 0
+		}
+		return hab.CompareVersions(a.version, b.version) > 0
+	},
+}
+
+// versionReleases is a version with the timestamp of its latest release.
+type versionReleases struct {
+	version       string
+	latestRelease string
+}
+
+// strategy returns the Strategy, highest if it is empty.
+func (r *DepotResolver) strategy() string {
+	if r.Strategy == "" {
+		return "highest"
+	}
+	return r.Strategy
+}
+
+// splitChannels splits a comma separated list of channels in order of precedence.
+func splitChannels(habChannels string) []string {
+	var channels []string
+	for _, channel := range strings.Split(habChannels, ",") {
+		if channel = strings.TrimSpace(channel); channel != "" {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+// Resolve returns the package version to install for the `pkgVerExp` expression and the channel
+// to install it from, the first of the comma separated `habChannels` which has a matching version.
+func (r *DepotResolver) Resolve(pkgName, pkgVerExp string, habChannels string) (Package, error) {
+	ident, err := hab.ParseIdent(pkgName)
+	if err != nil {
+		return Package{}, err
+	}
+	if _, ok := strategies[r.strategy()]; !ok {
+		return Package{}, fmt.Errorf("%v is invalid strategy, it must be one of highest, lowest or newest", r.Strategy)
+	}
+	// hab installs the highest version when no version is specified
+	if pkgVerExp == "" && r.strategy() != "highest" {
+		pkgVerExp = "*"
+	}
+	channels := splitChannels(habChannels)
+	if len(channels) == 0 {
+		return Package{}, errors.New("no channel is specified")
+	}
+
+	// the version in the identifier is used as it is
+	if ident.Version != "" {
+		if pkgVerExp != "" {
+			return Package{}, fmt.Errorf("%v already specifies the version", pkgName)
+		}
+		return Package{pkgName, "", channels[0]}, nil
+	}
+
+	// Use verExp as an exact package version if it is already installed
+	if r.Installer != nil && r.Installer.IsInstalled(Package{pkgName, pkgVerExp, channels[0]}) {
+		return Package{pkgName, pkgVerExp, channels[0]}, nil
+	}
+	if len(channels) == 1 {
+		// hab resolves the latest version itself, so there is nothing to report
+		if pkgVerExp == "" {
+			return Package{pkgName, "", channels[0]}, nil
+		}
+		version, err := r.packageVersion(ident, pkgVerExp, channels[0])
+		if err == nil && r.Verbose {
+			ident.Version = version
+			fmt.Fprintf(r.stderr(), "Using %s from channel %s\n", ident, channels[0])
+		}
+		return Package{pkgName, version, channels[0]}, err
+	}
+
+	var lastErr error
+	for _, channel := range channels {
+		var version string
+		if pkgVerExp == "" {
+			// the latest version is installed, so it only has to exist in the channel
+			versions, err := hab.PackageVersionsFromNameContext(r.context(), r.Depot, ident.Package(), channel)
+			if err != nil {
+				err = r.depotError(ident, err)
+			} else if len(versions) == 0 {
+				err = fmt.Errorf("%w: no versions of %s in channel %s", ErrNoMatchingVersion, ident.Package(), channel)
+			}
+			lastErr = err
+		} else {
+			version, lastErr = r.packageVersion(ident, pkgVerExp, channel)
+		}
+
+		if lastErr == nil {
+			ident.Version = version
+			fmt.Fprintf(r.stderr(), "Using %s from channel %s\n", ident, channel)
+			return Package{pkgName, version, channel}, nil
+		}
+	}
+
+	return Package{}, lastErr
+}
+
+// packageVersion returns the version of the package `ident` which the Strategy chooses for `pkgVerExp`.
+func (r *DepotResolver) packageVersion(ident hab.PackageIdent, pkgVerExp string, habChannel string) (string, error) {
+	versions, err := r.matchingVersions(ident, pkgVerExp, habChannel)
+	if err != nil {
+		return "", err
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return strategies[r.strategy()](versions[i], versions[j])
+	})
+	r.explainOrder("Order by "+r.strategy(), versions)
+
+	return versions[0].version, nil
+}
+
+// ResolveAll returns every package matching the `pkgVerExp` expression, or every one if it is empty.
+func (r *DepotResolver) ResolveAll(pkgName, pkgVerExp string, habChannels string) ([]Package, error) {
+	ident, err := hab.ParseIdent(pkgName)
+	if err != nil {
+		return nil, err
+	}
+	if ident.Version != "" {
+		return nil, fmt.Errorf("%v already specifies the version", pkgName)
+	}
+	channels := splitChannels(habChannels)
+	if len(channels) == 0 {
+		return nil, errors.New("no channel is specified")
+	}
+	if pkgVerExp == "" {
+		pkgVerExp = "*"
+	}
+
+	var lastErr error
+	for _, channel := range channels {
+		versions, err := r.matchingVersions(ident, pkgVerExp, channel)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		sort.SliceStable(versions, func(i, j int) bool {
+			return hab.CompareVersions(versions[i].version, versions[j].version) < 0
+		})
+		r.explainOrder("Order", versions)
+
+		var pkgs []Package
+		for _, v := range versions {
+			pkgs = append(pkgs, Package{pkgName, v.version, channel})
+		}
+		return pkgs, nil
+	}
+
+	return nil, lastErr
+}
+
+// matchingVersions returns the versions of the package `ident` which matched the `pkgVerExp` expression.
+func (r *DepotResolver) matchingVersions(ident hab.PackageIdent, pkgVerExp string, habChannel string) ([]versionReleases, error) {
+	var habErr error
+	exp := expression{raw: pkgVerExp}
+	exp.semver, _ = semver.NewConstraint(pkgVerExp)
+	exp.hab, habErr = hab.NewConstraint(pkgVerExp)
+	// if pkgVerExp is invalid for both expressions but empty or valid as a version, it returns
+	// pkgVerExp as it is
+	if exp.semver == nil && exp.hab == nil {
+		if pkgVerExp != "" && !versionValidator.MatchString(pkgVerExp) {
+			return nil, habErr
+		}
+		if r.Explain {
+			fmt.Fprintf(r.stderr(), "%s is not a version expression, so it is used as the version of %s as it is\n", pkgVerExp, ident.Package())
+		}
+		return []versionReleases{{version: pkgVerExp}}, nil
+	}
+
+	var depotErr error
+	depot, source := r.Depot, "depot"
+	foundPackages, err := hab.PackagesFromNameContext(r.context(), depot, ident.Package(), habChannel)
+	if err != nil {
+		depotErr = r.depotError(ident, err)
+		// only an unavailable depot falls back, and nothing does once the resolution is cancelled
+		if r.FallbackDepot == nil || r.context().Err() != nil || !errors.Is(depotErr, hab.ErrDepotUnavailable) {
+			return nil, depotErr
+		}
+		depot, source = r.FallbackDepot, r.fallbackSource()
+		fmt.Fprintf(r.stderr(), "ERROR: Unable to access Habitat depot API. %v\n"+
+			"Trying to fetch versions from %s...\n", err, source)
+		foundPackages, err = hab.PackagesFromNameContext(r.context(), depot, ident.Package(), habChannel)
+		if err != nil {
+			return nil, depotErr
+		}
+		fmt.Fprintf(r.stderr(), "WARNING: Resolving %s from %s instead of the depot, so it may be stale\n", ident.Package(), source)
+	}
+
+	var otherPackages []hab.PackageInfo
+	if r.Explain {
+		// the releases only in other channels are listed too, to tell why they are not used
+		otherPackages, _ = hab.PackagesFromNameContext(r.context(), depot, ident.Package(), "")
+	}
+	candidates := r.candidates(ident, exp, foundPackages, otherPackages)
+	if r.Explain {
+		r.explainCandidates(ident, exp, habChannel, source, candidates)
+	}
+
+	var versions []versionReleases
+	for _, c := range candidates {
+		if !c.matched {
+			continue
+		}
+		// if version exactly matches pkgVersionExp, it returns the version
+		if c.version == pkgVerExp {
+			return []versionReleases{{c.version, c.latestRelease}}, nil
+		}
+		versions = append(versions, c.versionReleases)
+	}
+
+	if len(versions) == 0 {
+		// the depot may have the version which the fallback has not
+		if depotErr != nil {
+			return nil, fmt.Errorf("%w (%v in %s)", depotErr, notFoundError(ident, exp, habChannel, candidates), source)
+		}
+		return nil, notFoundError(ident, exp, habChannel, candidates)
+	}
+	return versions, nil
+}
+
+// candidate is a version which was considered for an expression, and why it was accepted or
+// rejected.
+type candidate struct {
+	versionReleases
+	semver     bool
+	prerelease bool
+	inChannel  bool
+	matched    bool
+	reason     string
+}
+
+// candidates checks each version of packages in the channel and of otherPackages which are not,
+// and returns them from the lowest version to the highest.
+func (r *DepotResolver) candidates(ident hab.PackageIdent, exp expression, packages, otherPackages []hab.PackageInfo) []*candidate {
+	var candidates []*candidate
+	byVersion := map[string]*candidate{}
+	add := func(pkg hab.PackageInfo, inChannel bool) {
+		c, ok := byVersion[pkg.Version]
+		if !ok {
+			c = &candidate{versionReleases: versionReleases{version: pkg.Version}, inChannel: inChannel}
+			byVersion[pkg.Version] = c
+			candidates = append(candidates, c)
+		}
+		if c.inChannel == inChannel && pkg.Ident().Compare(hab.PackageIdent{Version: c.version, Release: c.latestRelease}) > 0 {
+			c.latestRelease = pkg.Release
+		}
+	}
+	for _, pkg := range packages {
+		add(pkg, true)
+	}
+	for _, pkg := range otherPackages {
+		add(pkg, false)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return hab.CompareVersions(candidates[i].version, candidates[j].version) < 0
+	})
+
+	for _, c := range candidates {
+		v, err := semver.NewVersion(c.version)
+		c.semver = err == nil
+		c.prerelease = err == nil && v.Prerelease() != ""
+		switch {
+		case !c.inChannel:
+			c.reason = "not in the channel"
+		case c.version == exp.raw:
+			c.matched, c.reason = true, "exact version"
+		default:
+			c.matched, c.reason = r.matches(ident, c.version, exp)
+		}
+	}
+	return candidates
+}
+
+// depotError returns the error for the depot failing to list the versions of ident.
+func (r *DepotResolver) depotError(ident hab.PackageIdent, err error) error {
+	if r.context().Err() != nil {
+		return context.Cause(r.context())
+	}
+	if errors.Is(err, hab.ErrPackageNotFound) || errors.Is(err, hab.ErrDepotUnavailable) {
+		return fmt.Errorf("unable to get versions of %s: %w", ident.Package(), err)
+	}
+	return fmt.Errorf("unable to get versions of %s: %w: %w", ident.Package(), hab.ErrDepotUnavailable, err)
+}
+
+// notFoundError returns the error for no version matching exp, which lists the candidates in
+// habChannel.
+func notFoundError(ident hab.PackageIdent, exp expression, habChannel string, candidates []*candidate) error {
+	var versions []string
+	for _, c := range candidates {
+		if !c.inChannel {
+			continue
+		}
+		if c.prerelease {
+			versions = append(versions, c.version+" (prerelease)")
+		} else {
+			versions = append(versions, c.version)
+		}
+	}
+
+	if len(versions) == 0 {
+		return fmt.Errorf("%w: no versions of %s in channel %s", ErrNoMatchingVersion, ident.Package(), habChannel)
+	}
+	return fmt.Errorf("%w: %s matches none of %s %s in channel %s", ErrNoMatchingVersion,
+		exp.raw, ident.Package(), strings.Join(versions, ", "), habChannel)
+}
+
+// explainCandidates reports the candidates for exp and why each of them was accepted or rejected.
+func (r *DepotResolver) explainCandidates(ident hab.PackageIdent, exp expression, habChannel, source string, candidates []*candidate) {
+	fmt.Fprintf(r.stderr(), "Candidates of %s for %s in channel %s from %s:\n", ident.Package(), exp.raw, habChannel, source)
+	if len(candidates) == 0 {
+		fmt.Fprintln(r.stderr(), "  (none)")
+		return
+	}
+
+	yesNo := map[bool]string{true: "yes", false: "no"}
+	w := tabwriter.NewWriter(r.stderr(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  VERSION\tRELEASE\tSEMVER\tCHANNEL\tRESULT")
+	for _, c := range candidates {
+		result := "rejected: " + c.reason
+		if c.matched {
+			result = "accepted: " + c.reason
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n", c.version, c.latestRelease, yesNo[c.semver], yesNo[c.inChannel], result)
+	}
+	w.Flush()
+}
+
+// explainOrder reports the order of the accepted versions.
+func (r *DepotResolver) explainOrder(label string, versions []versionReleases) {
+	if !r.Explain {
+		return
+	}
+	var ordered []string
+	for _, v := range versions {
+		ordered = append(ordered, v.version)
+	}
+	fmt.Fprintf(r.stderr(), "%s: %s\n", label, strings.Join(ordered, ", "))
+}
+
+// expression is a version expression parsed as a semver constraint and as a constraint of hab
+// versions. Either of them is nil when the expression is invalid for it.
+type expression struct {
+	raw    string
+	semver *semver.Constraints
+	hab    *hab.Constraint
+}
+
+// matches checks if version of the package `ident` matches the expression, and tells why.
+func (r *DepotResolver) matches(ident hab.PackageIdent, version string, exp expression) (bool, string) {
+	v, parseErr := semver.NewVersion(version)
+	// prereleases like 1.2.3-abc only match as hab versions, where they come before their releases
+	if parseErr == nil && v.Prerelease() != "" {
+		if exp.hab == nil || !exp.hab.Check(version) {
+			return false, "does not match"
+		}
+		if !r.AllowPrerelease {
+			if r.Verbose {
+				fmt.Fprintf(r.stderr(), "Excluded prerelease %s/%s which matches %s\n", ident.Package(), version, exp.raw)
+			}
+			return false, "prerelease, which is not allowed"
+		}
+		return true, "matches as hab version"
+	}
+
+	if parseErr == nil && exp.semver != nil {
+		if exp.semver.Check(v) {
+			return true, "matches as semver"
+		}
+		return false, "does not match"
+	}
+
+	// versions which are not semver, like 2017.11.1 or 1.0.2k, match as hab versions
+	if exp.hab != nil && exp.hab.Check(version) {
+		return true, "matches as hab version"
+	}
+	return false, "does not match"
+}
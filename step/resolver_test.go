@@ -0,0 +1,578 @@
+package step
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/screwdriver-cd/sd-step/hab"
+)
+
+type depotMock struct {
+	versions []string
+	err      error
+}
+
+func (depo *depotMock) PackageVersionsFromName(pkgName string, habChannel string) ([]string, error) {
+	if depo.err != nil {
+		return nil, depo.err
+	}
+	return depo.versions, nil
+}
+
+func (depo *depotMock) PackagesFromName(pkgName string, habChannel string) ([]hab.PackageInfo, error) {
+	versions, err := depo.PackageVersionsFromName(pkgName, habChannel)
+	return packagesOf(pkgName, versions), err
+}
+
+type channelDepotMock map[string][]string
+
+func (depo channelDepotMock) PackageVersionsFromName(pkgName string, habChannel string) ([]string, error) {
+	return depo[habChannel], nil
+}
+
+func (depo channelDepotMock) PackagesFromName(pkgName string, habChannel string) ([]hab.PackageInfo, error) {
+	return packagesOf(pkgName, depo[habChannel]), nil
+}
+
+// packagesOf returns a release of pkgName for each of versions.
+func packagesOf(pkgName string, versions []string) []hab.PackageInfo {
+	ident, _ := hab.ParseIdent(pkgName)
+	var packages []hab.PackageInfo
+	for _, version := range versions {
+		packages = append(packages, hab.PackageInfo{Origin: ident.Origin, Name: ident.Name, Version: version, Release: "20170524100001"})
+	}
+	return packages
+}
+
+// releaseDepotMock has packages with their releases. A package without channels is in every
+// channel.
+type releaseDepotMock []hab.PackageInfo
+
+func (depo releaseDepotMock) PackageVersionsFromName(pkgName string, habChannel string) ([]string, error) {
+	var versions []string
+	for _, pkg := range depo {
+		versions = append(versions, pkg.Version)
+	}
+	return versions, nil
+}
+
+func (depo releaseDepotMock) PackagesFromName(pkgName string, habChannel string) ([]hab.PackageInfo, error) {
+	var packages []hab.PackageInfo
+	for _, pkg := range depo {
+		inChannel := habChannel == "" || len(pkg.Channels) == 0
+		for _, channel := range pkg.Channels {
+			inChannel = inChannel || channel == habChannel
+		}
+		if inChannel {
+			packages = append(packages, pkg)
+		}
+	}
+	return packages, nil
+}
+
+func TestGetPackageVersionFromHabRoot(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.HabRoot = t.TempDir()
+	cfg.Stderr = new(bytes.Buffer)
+
+	for _, version := range []string{"1.1.9", "1.2.1", "1.2.2"} {
+		if err := os.MkdirAll(filepath.Join(cfg.HabRoot, "hab", "pkgs", "foo", "test", version, "20170524100001"), 0755); err != nil {
+			t.Fatalf("Unable to create package directory: %v", err)
+		}
+	}
+
+	r := NewResolver(&depotMock{nil, errors.New("depot error")}, nil, cfg)
+	version, err := r.packageVersion(hab.PackageIdent{Origin: "foo", Name: "test"}, "~1.2.0", "stable")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if version != "1.2.2" {
+		t.Errorf("Expected \"1.2.2\", actual \"%s\"", version)
+	}
+}
+
+func TestPackageVersionFallback(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.HabRoot = t.TempDir()
+	cfg.CacheDir = t.TempDir()
+	if err := os.MkdirAll(filepath.Join(cfg.HabRoot, "hab", "pkgs", "foo", "test", "1.1.9", "20170524100001"), 0755); err != nil {
+		t.Fatalf("Unable to create package directory: %v", err)
+	}
+	ident := hab.PackageIdent{Origin: "foo", Name: "test"}
+
+	// the versions listed while the depot is available are cached
+	depot := &depotMock{[]string{"1.1.9", "1.2.1", "1.2.2"}, nil}
+	cfg.Fallback = "cache"
+	if _, err := NewResolver(depot, nil, cfg).packageVersion(ident, "~1.2.0", "stable"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	depot.err = &hab.DepotError{StatusCode: 503}
+
+	tests := []struct {
+		fallback        string
+		expectedVersion string
+		expectedWarning string
+	}{
+		{"never", "", ""},
+		{"installed", "1.1.9", "WARNING: Resolving foo/test from installed packages instead of the depot"},
+		{"cache", "1.2.2", "WARNING: Resolving foo/test from cached depot versions instead of the depot"},
+	}
+
+	for _, test := range tests {
+		stderr := new(bytes.Buffer)
+		cfg.Fallback = test.fallback
+		cfg.Stderr = stderr
+		version, err := NewResolver(depot, nil, cfg).packageVersion(ident, "^1.0.0", "stable")
+
+		if test.expectedVersion == "" {
+			if !errors.Is(err, hab.ErrDepotUnavailable) {
+				t.Errorf("Expected ErrDepotUnavailable with fallback %s, actual %v", test.fallback, err)
+			}
+			if stderr.Len() != 0 {
+				t.Errorf("Expected no output with fallback %s, actual %q", test.fallback, stderr.String())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error with fallback %s: %v", test.fallback, err)
+		}
+		if version != test.expectedVersion {
+			t.Errorf("Expected %q with fallback %s, actual %q", test.expectedVersion, test.fallback, version)
+		}
+		if !strings.Contains(stderr.String(), test.expectedWarning) {
+			t.Errorf("Expected %q with fallback %s, actual %q", test.expectedWarning, test.fallback, stderr.String())
+		}
+	}
+}
+
+func TestPackageVersionFallbackTarget(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.HabRoot = t.TempDir()
+	cfg.CacheDir = t.TempDir()
+	cfg.Stderr = new(bytes.Buffer)
+	releaseDir := filepath.Join(cfg.HabRoot, "hab", "pkgs", "foo", "test", "1.1.9", "20170524100001")
+	if err := os.MkdirAll(releaseDir, 0755); err != nil {
+		t.Fatalf("Unable to create package directory: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(releaseDir, "TARGET"), []byte("aarch64-linux\n"), 0644); err != nil {
+		t.Fatalf("Unable to write TARGET: %v", err)
+	}
+	ident := hab.PackageIdent{Origin: "foo", Name: "test"}
+
+	// the versions are cached for aarch64-linux only
+	depot := &depotMock{[]string{"1.2.2"}, nil}
+	cfg.Fallback = "cache"
+	cfg.Target = "aarch64-linux"
+	if _, err := NewResolver(depot, nil, cfg).packageVersion(ident, "^1.0.0", "stable"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	depot.err = &hab.DepotError{StatusCode: 503}
+
+	for _, fallback := range []string{"installed", "cache"} {
+		cfg.Fallback = fallback
+		cfg.Target = "aarch64-linux"
+		if _, err := NewResolver(depot, nil, cfg).packageVersion(ident, "^1.0.0", "stable"); err != nil {
+			t.Errorf("Unexpected error with fallback %s on the target: %v", fallback, err)
+		}
+		cfg.Target = "x86_64-linux"
+		if _, err := NewResolver(depot, nil, cfg).packageVersion(ident, "^1.0.0", "stable"); !errors.Is(err, hab.ErrDepotUnavailable) {
+			t.Errorf("Expected ErrDepotUnavailable with fallback %s on another target, actual %v", fallback, err)
+		}
+	}
+}
+
+func TestPackageVersionFallbackWithoutMatch(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.HabRoot = t.TempDir()
+	cfg.Stderr = new(bytes.Buffer)
+	if err := os.MkdirAll(filepath.Join(cfg.HabRoot, "hab", "pkgs", "core", "node", "1.0.0", "20170524100001"), 0755); err != nil {
+		t.Fatalf("Unable to create package directory: %v", err)
+	}
+	depot := &depotMock{nil, &hab.DepotError{StatusCode: 503}}
+
+	_, err := NewResolver(depot, nil, cfg).packageVersion(hab.PackageIdent{Origin: "core", Name: "node"}, "^2.0.0", "stable")
+	if !errors.Is(err, hab.ErrDepotUnavailable) || errors.Is(err, ErrNoMatchingVersion) {
+		t.Errorf("Expected only ErrDepotUnavailable, actual %v", err)
+	}
+}
+
+func TestPackageVersionNotFoundWithoutFallback(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.HabRoot = t.TempDir()
+	stderr := new(bytes.Buffer)
+	cfg.Stderr = stderr
+	if err := os.MkdirAll(filepath.Join(cfg.HabRoot, "hab", "pkgs", "foo", "test", "1.1.9", "20170524100001"), 0755); err != nil {
+		t.Fatalf("Unable to create package directory: %v", err)
+	}
+	depot := &depotMock{nil, fmt.Errorf("%w: foo/test", hab.ErrPackageNotFound)}
+
+	_, err := NewResolver(depot, nil, cfg).packageVersion(hab.PackageIdent{Origin: "foo", Name: "test"}, "^1.0.0", "stable")
+	if !errors.Is(err, hab.ErrPackageNotFound) || errors.Is(err, hab.ErrDepotUnavailable) {
+		t.Errorf("Expected only ErrPackageNotFound, actual %v", err)
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("Expected no fallback, actual %q", stderr.String())
+	}
+}
+
+func TestPackageVersionCancelled(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.HabRoot = t.TempDir()
+	if err := os.MkdirAll(filepath.Join(cfg.HabRoot, "hab", "pkgs", "foo", "test", "1.1.9", "20170524100001"), 0755); err != nil {
+		t.Fatalf("Unable to create package directory: %v", err)
+	}
+	stderr := new(bytes.Buffer)
+	cfg.Stderr = stderr
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(&InterruptError{syscall.SIGINT})
+	cfg.Context = ctx
+
+	_, err := NewResolver(&depotMock{[]string{"1.1.9"}, nil}, nil, cfg).packageVersion(hab.PackageIdent{Origin: "foo", Name: "test"}, "^1.0.0", "stable")
+	var interrupted *InterruptError
+	if !errors.As(err, &interrupted) {
+		t.Errorf("Expected InterruptError, actual %v", err)
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("Expected no fallback, actual %q", stderr.String())
+	}
+}
+
+func TestResolvePackageVersion(t *testing.T) {
+	depot := channelDepotMock{
+		"unstable": {"1.2.0", "1.3.0", "2.0.0"},
+		"staging":  {"1.2.0", "1.3.0"},
+		"stable":   {"1.1.0", "1.2.0"},
+	}
+	cfg := DefaultConfig()
+	cfg.Stderr = new(bytes.Buffer)
+	r := NewResolver(depot, fakeHab(cfg), cfg)
+
+	tests := []struct {
+		versionExpression string
+		channels          string
+		expectedVersion   string
+		expectedChannel   string
+		expectError       bool
+	}{
+		{"^1.0.0", "stable", "1.2.0", "stable", false},
+		{"^1.0.0", "staging,stable", "1.3.0", "staging", false},
+		{"~1.1.0", "staging,stable", "1.1.0", "stable", false},
+		{"^2.0.0", "staging, stable", "", "", true},
+		{"^2.0.0", "unstable,staging,stable", "2.0.0", "unstable", false},
+		{"", "stable", "", "stable", false},
+		{"", "nightly,stable", "", "stable", false},
+		{"", ",", "", "", true},
+	}
+
+	for _, test := range tests {
+		pkg, err := r.Resolve("foo/test", test.versionExpression, test.channels)
+
+		if test.expectError {
+			if err == nil {
+				t.Errorf("Expected error for %q in %q, actual nil", test.versionExpression, test.channels)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if pkg.Version != test.expectedVersion || pkg.Channel != test.expectedChannel {
+			t.Errorf("Expected %q from %q for %q in %q, actual %q from %q",
+				test.expectedVersion, test.expectedChannel, test.versionExpression, test.channels, pkg.Version, pkg.Channel)
+		}
+	}
+}
+
+func TestResolvePackageVersionVerbose(t *testing.T) {
+	depot := channelDepotMock{
+		"staging": {"1.2.0", "1.3.0"},
+		"stable":  {"1.1.0", "1.2.0"},
+	}
+
+	tests := []struct {
+		verbose  bool
+		channels string
+		expected string
+	}{
+		{false, "stable", ""},
+		{true, "stable", "Using foo/test/1.2.0 from channel stable\n"},
+		{false, "staging,stable", "Using foo/test/1.3.0 from channel staging\n"},
+		{true, "staging,stable", "Using foo/test/1.3.0 from channel staging\n"},
+	}
+
+	for _, test := range tests {
+		stderr := new(bytes.Buffer)
+		r := &DepotResolver{Config: Config{Verbose: test.verbose, Stderr: stderr}, Depot: depot}
+		if _, err := r.Resolve("foo/test", "^1.0.0", test.channels); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if stderr.String() != test.expected {
+			t.Errorf("Expected %q for %q with verbose %v, actual %q", test.expected, test.channels, test.verbose, stderr.String())
+		}
+	}
+}
+
+func TestGetPackageVersions(t *testing.T) {
+	tests := []struct {
+		versionExpression string
+		foundVersions     []string
+		expectedVersion   string
+		depotError        error
+		expectedError     error
+	}{
+		{
+			versionExpression: "1.0.0",
+			foundVersions:     []string{"0.0.1", "0.1.0", "1.0.0", "2.0.0"},
+			expectedVersion:   "1.0.0",
+			depotError:        nil,
+			expectedError:     nil,
+		},
+		{
+			versionExpression: "^1.2.0",
+			foundVersions:     []string{"0.0.1", "0.1.0", "1.1.9", "1.2.1", "1.2.2", "1.3.0", "2.0.0"},
+			expectedVersion:   "1.3.0",
+			depotError:        nil,
+			expectedError:     nil,
+		},
+		{
+			versionExpression: "~1.2.0",
+			foundVersions:     []string{"0.0.1", "0.1.0", "1.1.9", "1.2.1", "1.2.2", "1.3.0", "2.0.0"},
+			expectedVersion:   "1.2.2",
+			depotError:        nil,
+			expectedError:     nil,
+		},
+		{
+			versionExpression: "",
+			foundVersions:     []string{"0.0.1", "0.1.0", "1.1.9", "1.2.1", "1.2.2", "1.3.0", "2.0.0"},
+			expectedVersion:   "",
+			depotError:        nil,
+			expectedError:     nil,
+		},
+		{
+			versionExpression: "1.0.0",
+			foundVersions:     []string{"0.0.1", "0.1.0", "1.1.9", "1.2.1", "1.2.2", "1.3.0", "2.0.0"},
+			expectedVersion:   "",
+			depotError:        errors.New("depot error"),
+			expectedError:     hab.ErrDepotUnavailable,
+		},
+		{
+			versionExpression: "~1.2.0",
+			foundVersions:     []string{"0.0.1", "0.1.0", "1.1.9", "1.2.1", "1.2.2", "1.2.3-abc", "1.3.0", "2.0.0"},
+			expectedVersion:   "1.2.2",
+			depotError:        nil,
+			expectedError:     nil,
+		},
+		{
+			versionExpression: "1.2.0-beta",
+			foundVersions:     []string{"0.0.1", "0.1.0", "1.1.9", "1.2.1", "1.2.2", "1.2.3-abc", "1.3.0", "2.0.0"},
+			expectedVersion:   "",
+			depotError:        nil,
+			expectedError:     ErrNoMatchingVersion,
+		},
+		{
+			versionExpression: ">=1.0 <",
+			foundVersions:     []string{"0.0.1", "0.1.0", "1.1.9"},
+			expectedVersion:   "",
+			depotError:        nil,
+			expectedError:     hab.ErrInvalidConstraint,
+		},
+		{
+			versionExpression: "1.0.0",
+			foundVersions:     nil,
+			expectedVersion:   "",
+			depotError:        hab.ErrPackageNotFound,
+			expectedError:     hab.ErrPackageNotFound,
+		},
+		{
+			versionExpression: "~1.0.1",
+			foundVersions:     []string{"1.0.1u", "1.0.2j", "1.0.2k", "1.1.0"},
+			expectedVersion:   "1.0.2k",
+			depotError:        nil,
+			expectedError:     nil,
+		},
+		{
+			versionExpression: "^9",
+			foundVersions:     []string{"9.5", "9.6", "10.0"},
+			expectedVersion:   "9.6",
+			depotError:        nil,
+			expectedError:     nil,
+		},
+		{
+			versionExpression: "~2017.11",
+			foundVersions:     []string{"2017.11.1", "2017.11.12", "2017.12.1"},
+			expectedVersion:   "2017.11.12",
+			depotError:        nil,
+			expectedError:     nil,
+		},
+		{
+			versionExpression: "1.2.3.x",
+			foundVersions:     []string{"1.2.3.4", "1.2.3.10", "1.2.4.0"},
+			expectedVersion:   "1.2.3.10",
+			depotError:        nil,
+			expectedError:     nil,
+		},
+	}
+
+	for _, test := range tests {
+		r := &DepotResolver{
+			Config:        Config{Stderr: new(bytes.Buffer)},
+			Depot:         &depotMock{test.foundVersions, test.depotError},
+			FallbackDepot: hab.NewDirDepot(t.TempDir()),
+		}
+		version, err := r.packageVersion(hab.PackageIdent{Origin: "foo", Name: "test"}, test.versionExpression, "stable")
+
+		if test.expectedError == nil && err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if test.expectedError != nil {
+			if !errors.Is(err, test.expectedError) {
+				t.Errorf("Expected error %q for %q, actual %v", test.expectedError, test.versionExpression, err)
+			}
+		} else {
+			if version != test.expectedVersion {
+				t.Errorf("Expected \"%s\", actual \"%s\"", test.expectedVersion, version)
+			}
+		}
+	}
+}
+
+func TestPackageVersionPrerelease(t *testing.T) {
+	foundVersions := []string{"1.1.9", "1.2.0-beta", "1.2.2", "1.2.3-abc", "1.3.0-rc1"}
+
+	tests := []struct {
+		versionExpression string
+		allowPrerelease   bool
+		expectedVersion   string
+		expectedExcluded  string
+		expectError       bool
+	}{
+		{"~1.2.0", false, "1.2.2", "Excluded prerelease foo/test/1.2.3-abc which matches ~1.2.0\n", false},
+		{"~1.2.0", true, "1.2.3-abc", "", false},
+		{">=1.2.0", true, "1.3.0-rc1", "", false},
+		{"^1.2.0-0", true, "1.3.0-rc1", "", false},
+		{">=1.2.3", true, "1.3.0-rc1", "", false},
+		{"~1.2.3", true, "", "", true},
+		{"1.2.0-beta", false, "1.2.0-beta", "", false},
+		{"^1.2.0-0", false, "1.2.2", "Excluded prerelease foo/test/1.2.0-beta which matches ^1.2.0-0\n" +
+			"Excluded prerelease foo/test/1.2.3-abc which matches ^1.2.0-0\n" +
+			"Excluded prerelease foo/test/1.3.0-rc1 which matches ^1.2.0-0\n", false},
+	}
+
+	for _, test := range tests {
+		stderr := new(bytes.Buffer)
+		r := &DepotResolver{
+			Config: Config{AllowPrerelease: test.allowPrerelease, Verbose: true, Stderr: stderr},
+			Depot:  &depotMock{foundVersions, nil},
+		}
+		version, err := r.packageVersion(hab.PackageIdent{Origin: "foo", Name: "test"}, test.versionExpression, "stable")
+
+		if test.expectError {
+			if err == nil {
+				t.Errorf("Expected error for %q, actual %q", test.versionExpression, version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", test.versionExpression, err)
+		}
+		if version != test.expectedVersion {
+			t.Errorf("Expected %q for %q with prereleases allowed %v, actual %q", test.expectedVersion, test.versionExpression, test.allowPrerelease, version)
+		}
+		if stderr.String() != test.expectedExcluded {
+			t.Errorf("Expected %q to be reported for %q, actual %q", test.expectedExcluded, test.versionExpression, stderr.String())
+		}
+	}
+}
+
+func TestPackageVersionStrategy(t *testing.T) {
+	depot := releaseDepotMock{
+		{Origin: "foo", Name: "test", Version: "1.1.0", Release: "20170524100001"},
+		{Origin: "foo", Name: "test", Version: "1.3.0", Release: "20170524100002"},
+		{Origin: "foo", Name: "test", Version: "1.2.0", Release: "20170524100003"},
+		{Origin: "foo", Name: "test", Version: "1.1.0", Release: "20170524100004"},
+		{Origin: "foo", Name: "test", Version: "2.0.0", Release: "20170524100005"},
+	}
+
+	tests := []struct {
+		strategy          string
+		versionExpression string
+		expectedVersion   string
+		expectError       bool
+	}{
+		{"", "^1.0.0", "1.3.0", false},
+		{"highest", "^1.0.0", "1.3.0", false},
+		{"lowest", "^1.0.0", "1.1.0", false},
+		{"lowest", ">=1.2.0", "1.2.0", false},
+		{"newest", "^1.0.0", "1.1.0", false},
+		{"newest", "~1.2.0 || ~1.3.0", "1.2.0", false},
+		{"lowest", "", "1.1.0", false},
+		{"highest", "", "", false},
+		{"oldest", "^1.0.0", "", true},
+	}
+
+	for _, test := range tests {
+		r := &DepotResolver{Config: Config{Strategy: test.strategy, Stderr: new(bytes.Buffer)}, Depot: depot}
+		pkg, err := r.Resolve("foo/test", test.versionExpression, "stable")
+
+		if test.expectError {
+			if err == nil {
+				t.Errorf("Expected error for strategy %q, actual %q", test.strategy, pkg.Version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for strategy %q: %v", test.strategy, err)
+		}
+		if pkg.Version != test.expectedVersion {
+			t.Errorf("Expected %q for %q with strategy %q, actual %q", test.expectedVersion, test.versionExpression, test.strategy, pkg.Version)
+		}
+	}
+}
+
+func TestPackageVersionExplain(t *testing.T) {
+	depot := releaseDepotMock{
+		{Origin: "foo", Name: "test", Version: "1.1.9", Release: "20170524100001", Channels: []string{"stable"}},
+		{Origin: "foo", Name: "test", Version: "1.2.2", Release: "20170524100002", Channels: []string{"stable"}},
+		{Origin: "foo", Name: "test", Version: "1.2.1", Release: "20170524100003", Channels: []string{"stable"}},
+		{Origin: "foo", Name: "test", Version: "1.2.3-abc", Release: "20170524100004", Channels: []string{"stable"}},
+		{Origin: "foo", Name: "test", Version: "1.2.4", Release: "20170524100005", Channels: []string{"unstable"}},
+		{Origin: "foo", Name: "test", Version: "1.2.1", Release: "20170524100006", Channels: []string{"unstable"}},
+	}
+
+	stderr := new(bytes.Buffer)
+	r := &DepotResolver{Config: Config{Explain: true, Stderr: stderr}, Depot: depot}
+	version, err := r.packageVersion(hab.PackageIdent{Origin: "foo", Name: "test"}, "~1.2.0", "stable")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if version != "1.2.2" {
+		t.Errorf("Expected 1.2.2, actual %q", version)
+	}
+
+	expected := "Candidates of foo/test for ~1.2.0 in channel stable from depot:\n" +
+		"  VERSION    RELEASE         SEMVER  CHANNEL  RESULT\n" +
+		"  1.1.9      20170524100001  yes     yes      rejected: does not match\n" +
+		"  1.2.1      20170524100003  yes     yes      accepted: matches as semver\n" +
+		"  1.2.2      20170524100002  yes     yes      accepted: matches as semver\n" +
+		"  1.2.3-abc  20170524100004  yes     yes      rejected: prerelease, which is not allowed\n" +
+		"  1.2.4      20170524100005  yes     no       rejected: not in the channel\n" +
+		"Order by highest: 1.2.2, 1.2.1\n"
+	if stderr.String() != expected {
+		t.Errorf("Expected explanation:\n%s\nactual:\n%s", expected, stderr.String())
+	}
+
+	_, err = r.packageVersion(hab.PackageIdent{Origin: "foo", Name: "test"}, "^2.0.0", "stable")
+	expectedError := "the specified version not found: ^2.0.0 matches none of foo/test 1.1.9, 1.2.1, 1.2.2, 1.2.3-abc (prerelease) in channel stable"
+	if err == nil || err.Error() != expectedError {
+		t.Errorf("Expected error %q, actual %v", expectedError, err)
+	}
+}
@@ -0,0 +1,181 @@
+package step
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// forwardedSignals are forwarded to the running command.
+var forwardedSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}
+
+// Runner runs command lines.
+type Runner interface {
+	// Run runs command until it exits or ctx is done.
+	Run(ctx context.Context, command string, output io.Writer) error
+	// RunInteractive runs command attached to the terminal.
+	RunInteractive(command string) error
+}
+
+// StderrRunner is a Runner which writes the error output of each command where it is told.
+type StderrRunner interface {
+	Runner
+	// RunWithStderr runs command like Run and writes its error output to stderr.
+	RunWithStderr(ctx context.Context, command string, output, stderr io.Writer) error
+}
+
+// ShellRunner runs command lines with sh and forwards signals to them.
+type ShellRunner struct {
+	// Command creates the command to run, exec.Command if it is nil.
+	Command func(name string, arg ...string) *exec.Cmd
+	// GracePeriod is how long an interrupted command may take to exit before it is killed.
+	GracePeriod time.Duration
+	// Stderr receives the error output of commands.
+	Stderr io.Writer
+}
+
+// NewShellRunner returns a new ShellRunner for cfg.
+func NewShellRunner(cfg Config) *ShellRunner {
+	return &ShellRunner{exec.Command, cfg.GracePeriod, cfg.stderr()}
+}
+
+// command returns the command running command line with sh.
+func (r *ShellRunner) command(command string) *exec.Cmd {
+	newCommand := r.Command
+	if newCommand == nil {
+		newCommand = exec.Command
+	}
+	return newCommand("sh", "-c", command)
+}
+
+// Run runs command until it exits or ctx is done, when the cause of ctx is returned.
+func (r *ShellRunner) Run(ctx context.Context, command string, output io.Writer) error {
+	return r.RunWithStderr(ctx, command, output, r.Stderr)
+}
+
+// RunWithStderr runs command like Run and writes its error output to stderr instead of Stderr.
+func (r *ShellRunner) RunWithStderr(ctx context.Context, command string, output, stderr io.Writer) error {
+	cmd := r.command(command)
+	cmd.Stdout = output
+	cmd.Stderr = stderr
+	// run in a new process group so that signals reach every descendant of the command
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	// a command is not started once ctx is done
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, forwardedSignals...)
+	defer signal.Stop(sigs)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return r.wait(ctx, cmd, sigs)
+}
+
+// wait waits for cmd to exit, and forwards a signal to its process group before killing it.
+func (r *ShellRunner) wait(ctx context.Context, cmd *exec.Cmd, sigs <-chan os.Signal) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var sig syscall.Signal
+	var err error
+	select {
+	case err := <-done:
+		return err
+	case s := <-sigs:
+		sig = s.(syscall.Signal)
+		err = &InterruptError{sig}
+	case <-ctx.Done():
+		// the signal which sd-step caught first is forwarded as it is
+		sig = syscall.SIGTERM
+		err = context.Cause(ctx)
+		var interrupted *InterruptError
+		if errors.As(err, &interrupted) {
+			sig = interrupted.Signal
+			// sigs receives the same signal, which must not kill the command like another one
+			select {
+			case <-sigs:
+			default:
+			}
+		}
+	}
+
+	pgid := cmd.Process.Pid
+	syscall.Kill(-pgid, sig)
+
+	timer := time.NewTimer(r.GracePeriod)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		syscall.Kill(-pgid, syscall.SIGKILL)
+		<-done
+	case <-sigs:
+		syscall.Kill(-pgid, syscall.SIGKILL)
+		<-done
+	}
+
+	return err
+}
+
+// RunInteractive runs command attached to the terminal of the current process.
+func (r *ShellRunner) RunInteractive(command string) error {
+	cmd := r.command(command)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, forwardedSignals...)
+	defer signal.Stop(sigs)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	for {
+		select {
+		case err := <-done:
+			return err
+		case sig := <-sigs:
+			// the terminal already delivers SIGINT to the shell, which handles it by itself
+			if sig != syscall.SIGINT {
+				cmd.Process.Signal(sig)
+			}
+		}
+	}
+}
+
+// phaseContext returns a context which expires after timeout, or never if timeout is zero,
+// unless parent is done.
+func phaseContext(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, timeout)
+}
+
+// phaseError converts the expiry of the context of phase into a TimeoutError.
+func phaseError(phase string, timeout time.Duration, err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &TimeoutError{phase, timeout}
+	}
+	return err
+}
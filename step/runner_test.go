@@ -0,0 +1,226 @@
+package step
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func fakeExecCommand(command string, args ...string) *exec.Cmd {
+	cs := []string{"-test.run=TestHelperProcess", "--", command}
+	cs = append(cs, args...)
+	cmd := exec.Command(os.Args[0], cs...)
+	cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1"}
+	return cmd
+}
+
+// fakeRunner returns a ShellRunner which runs the helper process instead of commands.
+func fakeRunner() *ShellRunner {
+	return &ShellRunner{Command: fakeExecCommand, GracePeriod: 100 * time.Millisecond}
+}
+
+func TestRunCommand(t *testing.T) {
+	r := fakeRunner()
+
+	stdout := new(bytes.Buffer)
+	err := r.Run(context.Background(), "sudo hab pkg install foo/bar", stdout)
+	expected := "run hab pkg install\n"
+	if err != nil {
+		t.Errorf("Run error = %q, should be nil", err)
+	}
+	if s := stdout.String(); s != expected {
+		t.Errorf("Expected '%v', actual '%v'", expected, s)
+	}
+
+	stdout = new(bytes.Buffer)
+	err = r.Run(context.Background(), "hab pkg exec foo/bar foo bar foobar", stdout)
+	expected = "run hab pkg exec\n"
+	if err != nil {
+		t.Errorf("Run error = %v, should be nil", err)
+	}
+	if s := stdout.String(); s != expected {
+		t.Errorf("Expected '%v', actual '%v'", expected, s)
+	}
+}
+
+func TestRunCommandCancelled(t *testing.T) {
+	r := fakeRunner()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(&InterruptError{syscall.SIGTERM})
+
+	stdout := new(bytes.Buffer)
+	err := r.Run(ctx, "hab pkg exec foo/bar foo", stdout)
+	var interrupted *InterruptError
+	if !errors.As(err, &interrupted) || interrupted.Signal != syscall.SIGTERM {
+		t.Errorf("Expected InterruptError by SIGTERM, actual %v", err)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("Expected the command not to be started, actual output %q", stdout.String())
+	}
+}
+
+func TestWaitCommandInterrupted(t *testing.T) {
+	r := fakeRunner()
+
+	tests := []struct {
+		command string
+		signal  syscall.Signal
+	}{
+		{"sleep", syscall.SIGTERM},
+		{"sleep", syscall.SIGHUP},
+		{"sleep-ignoring-signals", syscall.SIGTERM},
+	}
+
+	for _, test := range tests {
+		cmd := fakeExecCommand("sh", "-c", test.command)
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		stdout, _ := cmd.StdoutPipe()
+		if err := cmd.Start(); err != nil {
+			t.Fatalf("Unable to start command: %v", err)
+		}
+		// wait until the helper process has set up its signal handling
+		bufio.NewReader(stdout).ReadString('\n')
+
+		sigs := make(chan os.Signal, 1)
+		sigs <- test.signal
+		err := r.wait(context.Background(), cmd, sigs)
+
+		var interrupted *InterruptError
+		if !errors.As(err, &interrupted) {
+			t.Fatalf("Expected InterruptError, actual %v", err)
+		}
+		if interrupted.Signal != test.signal {
+			t.Errorf("Expected signal %v, actual %v", test.signal, interrupted.Signal)
+		}
+		if cmd.ProcessState == nil {
+			t.Errorf("Expected command to be reaped")
+		}
+	}
+}
+
+func TestWaitCommandForwardsSignal(t *testing.T) {
+	r := fakeRunner()
+
+	tests := []struct {
+		name      string
+		signal    bool
+		cancelled bool
+	}{
+		{"signal", true, false},
+		{"cancelled context", false, true},
+		{"signal and cancelled context", true, true},
+	}
+
+	for _, test := range tests {
+		cmd := fakeExecCommand("sh", "-c", "sleep-reporting-signals")
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		// the output is read after the command exits, which closes the pipe of StdoutPipe
+		stdout, w, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("Unable to create pipe: %v", err)
+		}
+		cmd.Stdout = w
+		if err := cmd.Start(); err != nil {
+			t.Fatalf("Unable to start command: %v", err)
+		}
+		w.Close()
+		output := bufio.NewReader(stdout)
+		output.ReadString('\n')
+
+		// sd-step receives SIGHUP, which either the runner or the context of sd-step catches
+		sigs := make(chan os.Signal, 1)
+		if test.signal {
+			sigs <- syscall.SIGHUP
+		}
+		ctx, cancel := context.WithCancelCause(context.Background())
+		if test.cancelled {
+			cancel(&InterruptError{syscall.SIGHUP})
+		}
+		errs := make(chan error, 1)
+		go func() {
+			errs <- r.wait(ctx, cmd, sigs)
+		}()
+
+		received, _ := output.ReadString('\n')
+		if received != "got hangup\n" {
+			t.Errorf("Expected the command to receive SIGHUP with %s, actual %q", test.name, received)
+		}
+		var interrupted *InterruptError
+		if err := <-errs; !errors.As(err, &interrupted) || interrupted.Signal != syscall.SIGHUP {
+			t.Errorf("Expected InterruptError of SIGHUP with %s, actual %v", test.name, err)
+		}
+		cancel(nil)
+		stdout.Close()
+	}
+}
+
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	defer os.Exit(0)
+	args := os.Args[:]
+	for i, val := range os.Args {
+		args = os.Args[i:]
+		if val == "-c" {
+			args = strings.Split(args[1:][0], " ")
+			break
+		}
+	}
+
+	// the environment variables which hab commands are run with do not change what they fake
+	for i, arg := range args {
+		if arg != "env" || i > 1 {
+			continue
+		}
+		rest := args[i+1:]
+		for len(rest) > 0 && strings.Contains(rest[0], "=") {
+			rest = rest[1:]
+		}
+		args = append(args[:i:i], rest...)
+		break
+	}
+
+	if len(args) == 5 && args[2] == "exec" && args[4] == "sleep" {
+		args = args[4:]
+	}
+
+	if len(args) == 1 && args[0] == "sleep-reporting-signals" {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+		fmt.Println("ready")
+		fmt.Printf("got %v\n", <-sigs)
+		return
+	}
+
+	if len(args) == 1 && strings.HasPrefix(args[0], "sleep") {
+		if args[0] == "sleep-ignoring-signals" {
+			signal.Ignore(syscall.SIGTERM, syscall.SIGHUP)
+		}
+		fmt.Println("ready")
+		time.Sleep(time.Minute)
+	}
+
+	if len(args) >= 4 {
+		if args[0] == "sudo" && args[3] == "install" ||
+			args[0] != "sudo" && args[2] == "install" {
+			fmt.Println("run hab pkg install")
+			return
+		} else if args[2] == "exec" {
+			fmt.Println("run hab pkg exec")
+			return
+		} else {
+			os.Exit(255)
+		}
+	}
+	os.Exit(255)
+}
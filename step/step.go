@@ -0,0 +1,339 @@
+package step
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/screwdriver-cd/sd-step/hab"
+)
+
+// DefaultDepotURL is base url for public depot of habitat.
+const DefaultDepotURL = "https://willem.habitat.sh/v1/depot"
+
+// DefaultHabPath is the path of the hab command in Screwdriver builds.
+const DefaultHabPath = "/opt/sd/bin/hab"
+
+// versionValidator accepts versions which start with a number like 8.9.0, 2017.11.1 or 1.0.2k.
+var versionValidator = regexp.MustCompile(`^\d[0-9A-Za-z_.+-]*$`)
+
+// Config configures how packages are resolved, installed and executed.
+type Config struct {
+	// DepotURL is the depot to resolve versions from, file:// URLs are read as local directories.
+	DepotURL string
+	// HabPath is the path of the hab command.
+	HabPath string
+	// HabRoot is the filesystem root which packages are installed into, "/" if it is empty.
+	HabRoot string
+	// Privilege is how packages are installed as non-root user: auto, sudo, sudo-n, doas or none.
+	Privilege string
+	// GracePeriod is how long an interrupted command may take to exit before it is killed.
+	GracePeriod time.Duration
+	// InstallTimeout limits how long installing a package may take, zero means no limit.
+	InstallTimeout time.Duration
+	// ExecTimeout limits how long executing a command may take, zero means no limit.
+	ExecTimeout time.Duration
+	// AllowPrerelease lets version constraints match prereleases like 1.2.3-abc.
+	AllowPrerelease bool
+	// Strategy chooses among the versions matching a constraint: highest, lowest or newest.
+	Strategy string
+	// Verbose reports the details of resolution to Stderr.
+	Verbose bool
+	// Explain reports every version considered for a constraint and why to Stderr.
+	Explain bool
+	// Fallback is where versions are resolved from without the depot: never, installed or cache.
+	Fallback string
+	// CacheDir is where the cache fallback saves versions, sd-step/depot in the user cache if empty.
+	CacheDir string
+	// DepotTimeout limits how long each request to the depot may take, zero means no limit.
+	DepotTimeout time.Duration
+	// Target is the platform which packages are for, such as aarch64-linux, any if it is empty.
+	Target string
+	// UserAgent is sent to the depot as the User-Agent header if it is not empty.
+	UserAgent string
+	// Context stops requests to the depot and commands when it is done, never if it is nil.
+	Context context.Context
+	// Stderr receives the error output of commands and warnings, os.Stderr if it is nil.
+	Stderr io.Writer
+}
+
+// DefaultConfig returns the configuration used by sd-step without options.
+func DefaultConfig() Config {
+	return Config{
+		DepotURL:     DefaultDepotURL,
+		HabPath:      DefaultHabPath,
+		Privilege:    "auto",
+		Strategy:     "highest",
+		Fallback:     "installed",
+		DepotTimeout: hab.DefaultTimeout,
+		Target:       hab.HostTarget(),
+		GracePeriod:  10 * time.Second,
+	}
+}
+
+// context returns the Context, which is never done if it is nil.
+func (cfg Config) context() context.Context {
+	if cfg.Context == nil {
+		return context.Background()
+	}
+	return cfg.Context
+}
+
+// stderr returns the writer for the error output.
+func (cfg Config) stderr() io.Writer {
+	if cfg.Stderr == nil {
+		return os.Stderr
+	}
+	return cfg.Stderr
+}
+
+// cacheDir returns the directory which the versions listed by the depot are saved into.
+func (cfg Config) cacheDir() string {
+	if cfg.CacheDir != "" {
+		return cfg.CacheDir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "sd-step", "depot")
+}
+
+// pkgsDir returns the directory which packages are installed into.
+func (cfg Config) pkgsDir() string {
+	return filepath.Join("/", cfg.HabRoot, "hab", "pkgs")
+}
+
+// Package is a package with the version and the channel to install it from.
+// An empty version means the latest version in the channel.
+type Package struct {
+	Name    string
+	Version string
+	Channel string
+}
+
+// Ident returns the identifier of the package which hab accepts.
+func (p Package) Ident() (hab.PackageIdent, error) {
+	ident, err := hab.ParseIdent(p.Name)
+	if err != nil {
+		return hab.PackageIdent{}, err
+	}
+	if p.Version == "" {
+		return ident, nil
+	}
+	if ident.Version != "" {
+		return hab.PackageIdent{}, fmt.Errorf("%v already specifies the version", p.Name)
+	}
+	if valid := versionValidator.MatchString(p.Version); !valid {
+		return hab.PackageIdent{}, fmt.Errorf("%v is invalid version", p.Version)
+	}
+	ident.Version = p.Version
+	return ident, nil
+}
+
+// Resolver resolves the version of a package.
+type Resolver interface {
+	// Resolve returns the package matching the `pkgVerExp` expression in the first of the
+	// comma separated `habChannels` which has a matching version.
+	Resolve(pkgName, pkgVerExp, habChannels string) (Package, error)
+	// ResolveAll returns every package matching the `pkgVerExp` expression in the first of
+	// the comma separated `habChannels` which has one, from the lowest version to the highest.
+	ResolveAll(pkgName, pkgVerExp, habChannels string) ([]Package, error)
+}
+
+// Installer installs packages.
+type Installer interface {
+	// IsInstalled checks if the package is installed.
+	IsInstalled(pkg Package) bool
+	// Install installs the package if it is not installed yet.
+	Install(pkg Package, output io.Writer) error
+}
+
+// Executor executes commands with installed packages.
+type Executor interface {
+	// Exec executes command with the runtime environment of pkg.
+	Exec(pkg Package, command []string, output io.Writer) error
+	// Shell starts an interactive shell with the runtime environment of pkgs.
+	Shell(pkgs []Package) error
+}
+
+// StderrExecutor is an Executor which writes the error output of each command where it is told.
+type StderrExecutor interface {
+	Executor
+	// ExecWithStderr executes command like Exec and writes its error output to stderr.
+	ExecWithStderr(pkg Package, command []string, output, stderr io.Writer) error
+}
+
+// Bundler moves packages to hosts without access to the depot.
+type Bundler interface {
+	// CreateBundle writes pkgs with their dependencies and origin keys into the file `output`.
+	CreateBundle(pkgs []Package, output string) error
+	// InstallBundle installs the packages in the bundle file.
+	InstallBundle(bundle string, output io.Writer) error
+}
+
+// Step resolves, installs and executes packages.
+type Step struct {
+	Resolver  Resolver
+	Installer Installer
+	Executor  Executor
+	Bundler   Bundler
+	Inspector Inspector
+	// Context stops Matrix from running more versions once it is done, never if it is nil.
+	Context context.Context
+	// Stderr receives the output of installing packages for Shell, os.Stderr if it is nil.
+	Stderr io.Writer
+}
+
+// New returns a Step which resolves versions from the depot of cfg and installs and
+// executes packages with its hab command.
+func New(cfg Config) (*Step, error) {
+	if cfg.Target != "" {
+		if err := hab.ValidateTarget(cfg.Target); err != nil {
+			return nil, err
+		}
+	}
+	opts := []hab.Option{hab.WithTimeout(cfg.DepotTimeout), hab.WithTarget(cfg.Target)}
+	if cfg.UserAgent != "" {
+		opts = append(opts, hab.WithUserAgent(cfg.UserAgent))
+	}
+	depot, err := hab.Open(cfg.DepotURL, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open depot: %v", err)
+	}
+	switch cfg.Fallback {
+	case "", "never", "installed", "cache":
+	default:
+		return nil, fmt.Errorf("%v is invalid fallback, it must be one of never, installed or cache", cfg.Fallback)
+	}
+
+	h := NewHab(cfg, NewShellRunner(cfg))
+	// a missing command to escalate with fails before any package is resolved or installed
+	if _, err := h.privilegePrefix(); err != nil {
+		return nil, err
+	}
+	return &Step{
+		Resolver:  NewResolver(depot, h, cfg),
+		Installer: h,
+		Executor:  h,
+		Bundler:   h,
+		Inspector: NewInspector(depot, h, cfg),
+		Context:   cfg.Context,
+		Stderr:    cfg.stderr(),
+	}, nil
+}
+
+// Exec installs the package matching the `pkgVerExp` expression and executes command with it.
+func (s *Step) Exec(pkgName, pkgVerExp, habChannels string, command []string, output io.Writer) error {
+	pkg, err := s.Resolver.Resolve(pkgName, pkgVerExp, habChannels)
+	if err != nil {
+		return fmt.Errorf("failed to get package version: %w", err)
+	}
+
+	if err := s.Installer.Install(pkg, output); err != nil {
+		return err
+	}
+	return s.Executor.Exec(pkg, command, output)
+}
+
+// Info returns the metadata of the package matching the `pkgVerExp` expression. A pkgName with a
+// version is described without resolving it, in any channel.
+func (s *Step) Info(pkgName, pkgVerExp, habChannels string) (Info, error) {
+	ident, err := hab.ParseIdent(pkgName)
+	if err != nil {
+		return Info{}, err
+	}
+
+	if ident.Version != "" && pkgVerExp != "" {
+		return Info{}, fmt.Errorf("%v already specifies the version", pkgName)
+	}
+
+	pkg := Package{Name: pkgName}
+	if ident.Version == "" {
+		// an empty expression means the latest version
+		if pkgVerExp == "" {
+			pkgVerExp = "*"
+		}
+		pkg, err = s.Resolver.Resolve(pkgName, pkgVerExp, habChannels)
+		if err != nil {
+			return Info{}, fmt.Errorf("failed to get package version: %w", err)
+		}
+	}
+	return s.Inspector.Inspect(pkg)
+}
+
+// Shell installs the packages of specs like core/node@^8 and starts an interactive shell with them.
+func (s *Step) Shell(specs []string, habChannels string) error {
+	pkgs, err := s.ResolveSpecs(specs, habChannels)
+	if err != nil {
+		return err
+	}
+
+	// the shell takes the terminal, so the output of installing goes to the error output
+	output := s.Stderr
+	if output == nil {
+		output = os.Stderr
+	}
+	for _, pkg := range pkgs {
+		if err := s.Installer.Install(pkg, output); err != nil {
+			return err
+		}
+	}
+	return s.Executor.Shell(pkgs)
+}
+
+// CreateBundle writes the packages of specs like core/node@^8 into the bundle file `output`.
+func (s *Step) CreateBundle(specs []string, habChannels string, output string) error {
+	pkgs, err := s.ResolveSpecs(specs, habChannels)
+	if err != nil {
+		return err
+	}
+	return s.Bundler.CreateBundle(pkgs, output)
+}
+
+// ResolveSpecs resolves package specs like core/node@^8.
+func (s *Step) ResolveSpecs(specs []string, habChannels string) ([]Package, error) {
+	var pkgs []Package
+	for _, spec := range specs {
+		pkgName, verExp := ParseSpec(spec)
+		pkg, err := s.Resolver.Resolve(pkgName, verExp, habChannels)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get package version of %s: %w", pkgName, err)
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+// ParseSpec splits a package spec like core/node@^8 into its name and version expression.
+func ParseSpec(spec string) (string, string) {
+	if i := strings.Index(spec, "@"); i >= 0 {
+		return spec[:i], spec[i+1:]
+	}
+	return spec, ""
+}
+
+// InterruptError is returned when the command is stopped by a forwarded signal.
+type InterruptError struct {
+	Signal syscall.Signal
+}
+
+func (e *InterruptError) Error() string {
+	return fmt.Sprintf("command interrupted by signal: %v", e.Signal)
+}
+
+// TimeoutError is returned when a phase does not finish within its timeout.
+type TimeoutError struct {
+	Phase   string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %v", e.Phase, e.Timeout)
+}
@@ -0,0 +1,84 @@
+package step
+
+import (
+	"testing"
+)
+
+func TestParseSpec(t *testing.T) {
+	tests := []struct {
+		spec            string
+		expectedName    string
+		expectedVersion string
+	}{
+		{"core/node", "core/node", ""},
+		{"core/node@^8", "core/node", "^8"},
+		{"core/node@8.9.0", "core/node", "8.9.0"},
+		{"core/node@", "core/node", ""},
+	}
+
+	for _, test := range tests {
+		name, version := ParseSpec(test.spec)
+		if name != test.expectedName || version != test.expectedVersion {
+			t.Errorf("ParseSpec(%q) = (%q, %q), expected (%q, %q)", test.spec, name, version, test.expectedName, test.expectedVersion)
+		}
+	}
+}
+
+func TestPackageIdent(t *testing.T) {
+	tests := []struct {
+		pkg         Package
+		expected    string
+		expectError bool
+	}{
+		{Package{"core/node", "", "stable"}, "core/node", false},
+		{Package{"core/node", "8.9.0", "stable"}, "core/node/8.9.0", false},
+		{Package{"core/openssl", "1.0.2k", "stable"}, "core/openssl/1.0.2k", false},
+		{Package{"core/node/8.9.0/20171101000000", "", "stable"}, "core/node/8.9.0/20171101000000", false},
+		{Package{"core/node/8.9.0", "8.9.0", "stable"}, "", true},
+		{Package{"core/node", "^8", "stable"}, "", true},
+		{Package{"core", "", "stable"}, "", true},
+	}
+
+	for _, test := range tests {
+		ident, err := test.pkg.Ident()
+		if test.expectError {
+			if err == nil {
+				t.Errorf("Expected error for %+v, actual %s", test.pkg, ident)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %+v: %v", test.pkg, err)
+		}
+		if ident.String() != test.expected {
+			t.Errorf("Expected %s, actual %s", test.expected, ident)
+		}
+	}
+}
+
+func TestNewPrivilege(t *testing.T) {
+	tests := []struct {
+		privilege   string
+		expectError bool
+	}{
+		{"none", false},
+		{"su", true},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		cfg := DefaultConfig()
+		cfg.DepotURL = "file://" + t.TempDir()
+		cfg.Privilege = test.privilege
+		// installing into a writable root needs no escalation with auto
+		cfg.HabRoot = t.TempDir()
+
+		_, err := New(cfg)
+		if test.expectError && err == nil {
+			t.Errorf("Expected error for privilege %q, actual nil", test.privilege)
+		}
+		if !test.expectError && err != nil {
+			t.Errorf("Unexpected error for privilege %q: %v", test.privilege, err)
+		}
+	}
+}